@@ -0,0 +1,248 @@
+package modbusdev
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API An http.Handler providing a REST interface to the registers of one or more devices.
+//
+//	GET /devices                          The devices available
+//	GET /devices/{name}/registers         The register definitions for the device
+//	GET /devices/{name}/values            The current values of all registers
+//	GET /devices/{name}/values/{code}     The current value of a register, by code or name
+//	PUT /devices/{name}/values/{code}     Write a value, given as {"value": 123.4}
+//
+// Values are given after the factor has been applied, and written values are converted back
+// before writing. Responses are JSON.
+type API struct {
+	// CacheTime Values are read from the device at most once in this period.
+	CacheTime time.Duration
+
+	mu      sync.Mutex
+	devices map[string]*apiDevice
+}
+
+type apiDevice struct {
+	mu       sync.Mutex
+	reader   *Reader
+	writer   *Writer
+	readings Readings
+}
+
+// APIDevice Details of a device returned by the API.
+type APIDevice struct {
+	Name     string `json:"name"`
+	Device   string `json:"device"`
+	SlaveID  byte   `json:"slave_id"`
+	Writable bool   `json:"writable"`
+}
+
+// APIRegister Details of a register or composite value returned by the API.
+type APIRegister struct {
+	Code        int     `json:"code"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Units       string  `json:"units,omitempty"`
+	Format      string  `json:"format"`
+	Factor      float64 `json:"factor,omitempty"`
+	Writable    bool    `json:"writable"`
+}
+
+// APIValues The values of a device returned by the API.
+type APIValues struct {
+	Device string         `json:"device"`
+	Time   time.Time      `json:"time"`
+	Values []ReadingValue `json:"values"`
+}
+
+// NewAPI Return an API with no devices.
+func NewAPI() *API {
+	return &API{devices: make(map[string]*apiDevice)}
+}
+
+// AddDevice Make the device available using the given name. If the writer is nil the device
+// is read only.
+func (api *API) AddDevice(name string, rdr *Reader, wrt *Writer) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.devices == nil {
+		api.devices = make(map[string]*apiDevice)
+	}
+	api.devices[strings.ToLower(name)] = &apiDevice{reader: rdr, writer: wrt}
+}
+
+// ServeHTTP Handle a request to the API.
+func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "devices" || len(parts) > 4 {
+		apiError(w, http.StatusNotFound, fmt.Errorf("Not found"))
+		return
+	}
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			apiError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %s not allowed", r.Method))
+			return
+		}
+		apiJSON(w, http.StatusOK, api.list())
+		return
+	}
+
+	api.mu.Lock()
+	dev, ck := api.devices[strings.ToLower(parts[1])]
+	api.mu.Unlock()
+	if !ck {
+		apiError(w, http.StatusNotFound, fmt.Errorf("Device '%s' not found", parts[1]))
+		return
+	}
+	if len(parts) < 3 {
+		apiError(w, http.StatusNotFound, fmt.Errorf("Not found"))
+		return
+	}
+
+	switch {
+	case parts[2] == "registers" && len(parts) == 3 && r.Method == http.MethodGet:
+		apiJSON(w, http.StatusOK, dev.registers())
+	case parts[2] == "values" && len(parts) == 3 && r.Method == http.MethodGet:
+		readings, err := dev.read(api.CacheTime)
+		if err != nil {
+			apiError(w, http.StatusBadGateway, err)
+			return
+		}
+		values := APIValues{Device: readings.Device, Time: readings.Time}
+		for _, code := range readings.Codes() {
+			values.Values = append(values.Values, readings.Value(code))
+		}
+		apiJSON(w, http.StatusOK, values)
+	case parts[2] == "values" && len(parts) == 4 && r.Method == http.MethodGet:
+		code, err := dev.reader.Code(parts[3])
+		if err != nil {
+			apiError(w, http.StatusNotFound, err)
+			return
+		}
+		readings, err := dev.read(api.CacheTime)
+		if err != nil {
+			apiError(w, http.StatusBadGateway, err)
+			return
+		}
+		if _, ck := readings.Values[code]; !ck {
+			apiError(w, http.StatusNotFound, fmt.Errorf("Code %d is not available", code))
+			return
+		}
+		apiJSON(w, http.StatusOK, readings.Value(code))
+	case parts[2] == "values" && len(parts) == 4 && r.Method == http.MethodPut:
+		api.write(w, r, dev, parts[3])
+	case parts[2] == "registers" || parts[2] == "values":
+		apiError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %s not allowed", r.Method))
+	default:
+		apiError(w, http.StatusNotFound, fmt.Errorf("Not found"))
+	}
+}
+
+func (api *API) list() []APIDevice {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	devices := make([]APIDevice, 0, len(api.devices))
+	for name, dev := range api.devices {
+		devices = append(devices, APIDevice{name, dev.reader.device, dev.reader.slaveID, dev.writer != nil})
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+	return devices
+}
+
+// write Validate the value supplied and write it to the device, returning the new value.
+func (api *API) write(w http.ResponseWriter, r *http.Request, dev *apiDevice, name string) {
+	if dev.writer == nil {
+		apiError(w, http.StatusMethodNotAllowed, fmt.Errorf("Device is read only"))
+		return
+	}
+	code, err := dev.reader.Code(name)
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	reg, ck := dev.writer.registers[code]
+	if !ck || !reg.writable() {
+		apiError(w, http.StatusBadRequest, fmt.Errorf("Register %s cannot be written", name))
+		return
+	}
+
+	var body struct {
+		Value *float64 `json:"value"`
+	}
+	if err = json.NewDecoder(r.Body).Decode(&body); err != nil || body.Value == nil {
+		apiError(w, http.StatusBadRequest, fmt.Errorf("Body must be JSON with a numeric value, e.g. {\"value\": 10}"))
+		return
+	}
+
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if err = dev.writer.WriteFactored(code, *body.Value); err != nil {
+		status := http.StatusBadGateway
+		var rangeErr RangeError
+		if errors.As(err, &rangeErr) {
+			status = http.StatusBadRequest
+		}
+		apiError(w, status, err)
+		return
+	}
+	dev.readings = Readings{}
+	val, err := dev.reader.ReadRegister(code, true)
+	if err != nil {
+		apiError(w, http.StatusBadGateway, err)
+		return
+	}
+	readings := Readings{Device: dev.reader.device, SlaveID: dev.reader.slaveID, Time: time.Now(),
+		Registers: dev.reader.registers, Values: map[int]Value{code: val}, Factored: true}
+	apiJSON(w, http.StatusOK, readings.Value(code))
+}
+
+func (dev *apiDevice) registers() []APIRegister {
+	var regs []APIRegister
+	for code, reg := range dev.reader.registers {
+		writable := false
+		if dev.writer != nil {
+			_, writable = dev.writer.registers[code]
+		}
+		regs = append(regs, APIRegister{code, dev.reader.names[code], reg.Description, reg.Units, reg.Format, reg.Factor,
+			writable && reg.writable()})
+	}
+	for code, comp := range dev.reader.composites {
+		regs = append(regs, APIRegister{Code: code, Name: comp.Name, Description: comp.Description,
+			Format: comp.Format})
+	}
+	sort.Slice(regs, func(i, j int) bool { return regs[i].Code < regs[j].Code })
+	return regs
+}
+
+// read Return the readings for the device, reading it if the last readings are older than
+// the cache time.
+func (dev *apiDevice) read(cacheTime time.Duration) (Readings, error) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if !dev.readings.Time.IsZero() && time.Since(dev.readings.Time) < cacheTime {
+		return dev.readings, nil
+	}
+	readings, err := dev.reader.Readings(true)
+	if err != nil {
+		return readings, err
+	}
+	dev.readings = readings
+	return readings, nil
+}
+
+func apiJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func apiError(w http.ResponseWriter, status int, err error) {
+	apiJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,149 @@
+package modbusdev
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testAPI(t *testing.T) (*API, *FakeClient) {
+	client := NewFakeClient()
+	client.Input[0x0a] = 1234
+	client.Holding[0x90] = 200
+	rdr, err := NewReader(client, "solaxx1hybridex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrt, err := NewWriter(client, "solaxx1hybridex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meter, err := NewReader(NewFakeClient(), "sdm230")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := NewAPI()
+	api.AddDevice("inverter", &rdr, &wrt)
+	api.AddDevice("meter", &meter, nil)
+	return api, client
+}
+
+func apiRequest(t *testing.T, api *API, method, path, body string, result interface{}) int {
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Incorrect content type %s for %s", rec.Header().Get("Content-Type"), path)
+	}
+	if result != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), result); err != nil {
+			t.Fatalf("Unable to decode response for %s: %s", path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestAPIDevices(t *testing.T) {
+	api, _ := testAPI(t)
+	var devices []APIDevice
+	if status := apiRequest(t, api, "GET", "/devices", "", &devices); status != http.StatusOK {
+		t.Fatalf("Incorrect status. Got %d expected 200", status)
+	}
+	if len(devices) != 2 || devices[0].Name != "inverter" || !devices[0].Writable || devices[1].Writable {
+		t.Fatalf("Incorrect devices %+v", devices)
+	}
+
+	var regs []APIRegister
+	apiRequest(t, api, "GET", "/devices/inverter/registers", "", &regs)
+	found := false
+	for _, reg := range regs {
+		if reg.Code == 40145 {
+			found = reg.Writable && reg.Name == "charge_max_current" && reg.Units == "A"
+		}
+		if reg.Code == 30011 && reg.Writable {
+			t.Fatal("Input register should not be writable")
+		}
+	}
+	if !found {
+		t.Fatal("Register 40145 not found or incorrect")
+	}
+
+	if status := apiRequest(t, api, "GET", "/devices/unknown/values", "", nil); status != http.StatusNotFound {
+		t.Fatalf("Incorrect status. Got %d expected 404", status)
+	}
+	if status := apiRequest(t, api, "DELETE", "/devices/inverter/values", "", nil); status != http.StatusMethodNotAllowed {
+		t.Fatalf("Incorrect status. Got %d expected 405", status)
+	}
+}
+
+func TestAPIValues(t *testing.T) {
+	api, _ := testAPI(t)
+	var values APIValues
+	apiRequest(t, api, "GET", "/devices/inverter/values", "", &values)
+	if values.Device != "solaxx1hybridex" || time.Since(values.Time) > time.Minute {
+		t.Fatalf("Incorrect values %+v", values)
+	}
+	for _, val := range values.Values {
+		if val.Code == 30011 && (val.Value != 1234.0 || val.Units != "W") {
+			t.Fatalf("Incorrect value %+v", val)
+		}
+		if val.Code == 940163 && val.Value != "00:00:00:00:00:00" {
+			t.Fatalf("Incorrect composite value %+v", val)
+		}
+	}
+
+	var val ReadingValue
+	if status := apiRequest(t, api, "GET", "/devices/inverter/values/charge_max_current", "", &val); status != http.StatusOK {
+		t.Fatalf("Incorrect status. Got %d expected 200", status)
+	}
+	if val.Code != 40145 || val.Value != 20.0 || val.Raw == nil || *val.Raw != 200 {
+		t.Fatalf("Incorrect value %+v", val)
+	}
+	if status := apiRequest(t, api, "GET", "/devices/inverter/values/no_such_register", "", nil); status != http.StatusNotFound {
+		t.Fatalf("Incorrect status. Got %d expected 404", status)
+	}
+}
+
+func TestAPIWrite(t *testing.T) {
+	api, client := testAPI(t)
+	var val ReadingValue
+	if status := apiRequest(t, api, "PUT", "/devices/inverter/values/40145", `{"value": 12.5}`, &val); status != http.StatusOK {
+		t.Fatalf("Incorrect status. Got %d expected 200", status)
+	}
+	if client.Holding[0x24] != 125 {
+		t.Fatalf("Incorrect value. Got %d expected 125", client.Holding[0x24])
+	}
+
+	tests := []struct {
+		path   string
+		body   string
+		status int
+	}{
+		{"/devices/inverter/values/40145", `{"value": -1}`, http.StatusBadRequest},
+		{"/devices/inverter/values/40145", `12.5`, http.StatusBadRequest},
+		{"/devices/inverter/values/pv1_power", `{"value": 1}`, http.StatusBadRequest},
+		{"/devices/meter/values/30013", `{"value": 1}`, http.StatusMethodNotAllowed},
+		{"/devices/meterex/values/40013", `{"value": 100}`, http.StatusBadRequest},
+	}
+	meter, err := NewReader(NewFakeClient(), "sdm230ex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meterWriter, err := NewWriter(NewFakeClient(), "sdm230ex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.AddDevice("meterex", &meter, &meterWriter)
+	for _, reg := range api.devices["meterex"].registers() {
+		if reg.Code == 40013 && reg.Writable {
+			t.Fatalf("ieee32 register listed as writable %+v", reg)
+		}
+	}
+	for _, tst := range tests {
+		if status := apiRequest(t, api, "PUT", tst.path, tst.body, nil); status != tst.status {
+			t.Fatalf("Incorrect status for %s %s. Got %d expected %d", tst.path, tst.body, status, tst.status)
+		}
+	}
+}
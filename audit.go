@@ -0,0 +1,58 @@
+package modbusdev
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditRecord Details of a single write made through a Writer. The previous value is read from
+// the device immediately before the write is attempted. Writes made with WriteDirect to an
+// address that is not used by a known register have no code, description or previous value.
+type AuditRecord struct {
+	Time        time.Time `json:"time"`
+	Device      string    `json:"device"`
+	Code        int       `json:"code,omitempty"`
+	Address     uint16    `json:"address"`
+	Description string    `json:"description,omitempty"`
+	Previous    Value     `json:"previous"`
+	New         Value     `json:"new"`
+	Result      string    `json:"result"`
+}
+
+// AuditHook Interface that should be implemented by anything wishing to receive details of
+// writes made through a Writer.
+type AuditHook interface {
+	Audit(record AuditRecord) error
+}
+
+// AuditFile An AuditHook that appends each record as a line of JSON to a file.
+type AuditFile struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// NewAuditFile Open (or create) the named file for appending audit records.
+func NewAuditFile(filename string) (*AuditFile, error) {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditFile{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Audit Write the record to the file as a single line of JSON.
+func (af *AuditFile) Audit(record AuditRecord) error {
+	af.mu.Lock()
+	defer af.mu.Unlock()
+	return af.encoder.Encode(record)
+}
+
+// Close Close the underlying file.
+func (af *AuditFile) Close() error {
+	af.mu.Lock()
+	defer af.mu.Unlock()
+	return af.file.Close()
+}
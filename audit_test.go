@@ -0,0 +1,112 @@
+package modbusdev
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type memoryAudit struct {
+	records []AuditRecord
+}
+
+func (ma *memoryAudit) Audit(record AuditRecord) error {
+	ma.records = append(ma.records, record)
+	return nil
+}
+
+func TestWriterAudit(t *testing.T) {
+	client := NewFakeClient()
+	wrt := Writer{client: client, device: "test", registers: map[int]Register{
+		40001: {"Test Setting", "", 0, "u16", 1},
+	}}
+	client.Holding[0] = 12
+	audit := &memoryAudit{}
+	wrt.SetAudit(audit)
+
+	if err := wrt.WriteSimple(40001, 34); err != nil {
+		t.Fatalf("Unexpected error writing register: %s", err)
+	}
+	if len(audit.records) != 1 {
+		t.Fatalf("Expected 1 audit record, got %d", len(audit.records))
+	}
+	rec := audit.records[0]
+	if rec.Code != 40001 || rec.Description != "Test Setting" || rec.Device != "test" {
+		t.Fatalf("Incorrect audit record details: %+v", rec)
+	}
+	if rec.Previous.Unsigned16 != 12 || rec.New.Unsigned16 != 34 {
+		t.Fatalf("Incorrect audit values. Got %d -> %d expected 12 -> 34", rec.Previous.Unsigned16, rec.New.Unsigned16)
+	}
+	if rec.Result != "ok" {
+		t.Fatalf("Incorrect audit result %s", rec.Result)
+	}
+}
+
+func TestAuditFile(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "audit.log")
+	af, err := NewAuditFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := af.Audit(AuditRecord{Device: "test", Code: 40001 + i, Result: "ok"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	af.Close()
+
+	file, err := os.Open(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	n := 0
+	for ; scanner.Scan(); n++ {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("Unable to decode audit line %d: %s", n, err)
+		}
+		if rec.Code != 40001+n {
+			t.Fatalf("Incorrect code on line %d. Got %d", n, rec.Code)
+		}
+	}
+	if n != 2 {
+		t.Fatalf("Expected 2 lines in audit file, got %d", n)
+	}
+}
+
+func TestWriteDirectAudit(t *testing.T) {
+	client := NewFakeClient()
+	client.SetHolding(0x8C, 10)
+	client.SetHolding(0x22, 4700)
+	wrt, err := NewWriter(client, "solaxx1hybridex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit := &memoryAudit{}
+	wrt.SetAudit(audit)
+
+	if err = wrt.WriteDirect(0x22, 20); err != nil {
+		t.Fatal(err)
+	}
+	if err = wrt.WriteDirect(0x26, 0x0102); err != nil {
+		t.Fatal(err)
+	}
+	if len(audit.records) != 2 {
+		t.Fatalf("Expected 2 audit records, got %d", len(audit.records))
+	}
+	rec := audit.records[0]
+	if rec.Code != 40140 || rec.Address != 0x22 || rec.Description != "Min Charger Capacity" {
+		t.Fatalf("Incorrect audit record details: %+v", rec)
+	}
+	if rec.Previous.Unsigned16 != 10 || rec.New.Unsigned16 != 20 {
+		t.Fatalf("Incorrect audit values. Got %d -> %d expected 10 -> 20", rec.Previous.Unsigned16, rec.New.Unsigned16)
+	}
+	rec = audit.records[1]
+	if rec.Code != 0 || rec.Address != 0x26 || rec.Description != "" || rec.New.Unsigned16 != 0x0102 {
+		t.Fatalf("Incorrect audit record for an unknown address: %+v", rec)
+	}
+}
@@ -0,0 +1,97 @@
+package modbusdev
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PostgreSQL allows at most 65535 parameters in a single statement.
+const maxStatementParams = 65535
+
+// multiInsert Return a single statement that inserts all the rows, along with the arguments for
+// the statement.
+func multiInsert(table string, columns []string, rows [][]interface{}) (string, []interface{}) {
+	tuples := make([]string, 0, len(rows))
+	args := make([]interface{}, 0, len(rows)*len(columns))
+	for _, row := range rows {
+		placeholders := make([]string, len(row))
+		for i := range placeholders {
+			placeholders[i] = fmt.Sprintf("$%d", len(args)+i+1)
+		}
+		tuples = append(tuples, "("+strings.Join(placeholders, ",")+")")
+		args = append(args, row...)
+	}
+	qry := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", table, strings.Join(columns, ", "), strings.Join(tuples, ","))
+	return qry, args
+}
+
+// rowBatch Rows that are held until size rows are waiting, or interval has passed since the
+// first was added, and then inserted using as few statements as possible.
+type rowBatch struct {
+	mu       sync.Mutex
+	table    string
+	columns  []string
+	size     int
+	interval time.Duration
+	rows     [][]interface{}
+	timer    *time.Timer
+	exec     func(qry string, args ...interface{}) error
+}
+
+func newRowBatch(table string, columns []string, size int, interval time.Duration, exec func(string, ...interface{}) error) *rowBatch {
+	return &rowBatch{table: table, columns: columns, size: size, interval: interval, exec: exec}
+}
+
+// add Add the rows to the batch, inserting the batch if it is full. If inserting fails the rows
+// are kept for the next attempt, but only up to 10 times the batch size.
+func (rb *rowBatch) add(rows ...[]interface{}) error {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.rows = append(rb.rows, rows...)
+	if len(rb.rows) >= rb.size {
+		err := rb.flushRows()
+		if over := len(rb.rows) - rb.size*10; over > 0 {
+			log.Printf("Dropping %d rows waiting to be inserted into %s", over, rb.table)
+			rb.rows = rb.rows[over:]
+		}
+		return err
+	}
+	if rb.timer == nil && rb.interval > 0 {
+		rb.timer = time.AfterFunc(rb.interval, func() {
+			if err := rb.flush(); err != nil {
+				log.Printf("Error inserting batch into %s: %s", rb.table, err)
+			}
+		})
+	}
+	return nil
+}
+
+// flush Insert any rows waiting.
+func (rb *rowBatch) flush() error {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.flushRows()
+}
+
+func (rb *rowBatch) flushRows() error {
+	if rb.timer != nil {
+		rb.timer.Stop()
+		rb.timer = nil
+	}
+	perStatement := maxStatementParams / len(rb.columns)
+	for len(rb.rows) > 0 {
+		n := len(rb.rows)
+		if n > perStatement {
+			n = perStatement
+		}
+		qry, args := multiInsert(rb.table, rb.columns, rb.rows[:n])
+		if err := rb.exec(qry, args...); err != nil {
+			return err
+		}
+		rb.rows = rb.rows[n:]
+	}
+	return nil
+}
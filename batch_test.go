@@ -0,0 +1,126 @@
+package modbusdev
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type execRecorder struct {
+	mu      sync.Mutex
+	queries []string
+	rows    int
+	fail    bool
+}
+
+func (er *execRecorder) exec(qry string, args ...interface{}) error {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	if er.fail {
+		return fmt.Errorf("insert failed")
+	}
+	er.queries = append(er.queries, qry)
+	er.rows += len(args) / 2
+	return nil
+}
+
+func (er *execRecorder) counts() (int, int) {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	return len(er.queries), er.rows
+}
+
+func TestMultiInsert(t *testing.T) {
+	qry, args := multiInsert("solax", []string{"time", "pv1"}, [][]interface{}{{1, 2}, {3, 4}})
+	if qry != "INSERT INTO solax (time, pv1) VALUES ($1,$2),($3,$4)" {
+		t.Fatalf("Incorrect query: %s", qry)
+	}
+	if len(args) != 4 || args[2] != 3 {
+		t.Fatalf("Incorrect args: %v", args)
+	}
+}
+
+func TestRowBatchSize(t *testing.T) {
+	rec := &execRecorder{}
+	rb := newRowBatch("solax", []string{"time", "pv1"}, 3, 0, rec.exec)
+	for i := 0; i < 7; i++ {
+		if err := rb.add([]interface{}{i, i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, rows := rec.counts(); n != 2 || rows != 6 {
+		t.Fatalf("Expected 2 inserts of 6 rows, got %d of %d", n, rows)
+	}
+	if err := rb.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n, rows := rec.counts(); n != 3 || rows != 7 {
+		t.Fatalf("Expected 3 inserts of 7 rows after flush, got %d of %d", n, rows)
+	}
+
+	rec.fail = true
+	for i := 0; i < 3; i++ {
+		rb.add([]interface{}{i, i})
+	}
+	if len(rb.rows) != 3 {
+		t.Fatalf("Rows should be kept when insert fails, have %d", len(rb.rows))
+	}
+}
+
+func TestRowBatchInterval(t *testing.T) {
+	rec := &execRecorder{}
+	rb := newRowBatch("solax", []string{"time", "pv1"}, 100, 20*time.Millisecond, rec.exec)
+	rb.add([]interface{}{1, 1})
+	rb.add([]interface{}{2, 2})
+	for i := 0; i < 50; i++ {
+		if n, _ := rec.counts(); n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n, rows := rec.counts(); n != 1 || rows != 2 {
+		t.Fatalf("Expected 1 insert of 2 rows after interval, got %d of %d", n, rows)
+	}
+}
+
+func TestRowBatchParamLimit(t *testing.T) {
+	rec := &execRecorder{}
+	rb := newRowBatch("solax", []string{"time", "pv1"}, 40000, 0, rec.exec)
+	for i := 0; i < 40000; i++ {
+		rb.add([]interface{}{i, i})
+	}
+	if n, rows := rec.counts(); n != 2 || rows != 40000 {
+		t.Fatalf("Expected 2 inserts of 40000 rows, got %d of %d", n, rows)
+	}
+}
+
+func TestDatabaseBatchConfig(t *testing.T) {
+	dbC := DatabaseConnection{Table: "solax", FlushInterval: 5, Fields: []DatabaseField{{Name: "pv1", Code: 30011}}}
+	err := dbC.OpenDatabase()
+	if err == nil || err.Error() != "FlushInterval can only be used with a BatchSize greater than 1" {
+		t.Fatalf("Incorrect error for FlushInterval without BatchSize. Got %v", err)
+	}
+
+	defer func(orig string) { postgresDriver = orig }(postgresDriver)
+	postgresDriver = "modbusdev-test"
+	testDriver.setDown(false)
+	start := len(testDriver.inserted())
+	dbC.BatchSize = 10
+	dbC.FlushInterval = 0.02
+	if err = dbC.OpenDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	defer dbC.Close()
+	for i := 0; i < 2; i++ {
+		if err = dbC.Execute(map[int]Value{30011: {Ieee32: 1500}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 50 && len(testDriver.inserted()) == start; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if inserts := testDriver.inserted()[start:]; len(inserts) != 1 || len(inserts[0]) != 4 {
+		t.Fatalf("Expected 1 insert of 2 rows after the interval, got %v", inserts)
+	}
+}
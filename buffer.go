@@ -0,0 +1,381 @@
+package modbusdev
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxQueue = 1000
+	defaultRetryMin = 1.0
+	defaultRetryMax = 60.0
+)
+
+// BufferedSink A Sink that queues readings and passes them to another Sink from a background
+// goroutine, so Write never waits for (or fails because of) the other Sink. Failed writes are
+// retried with increasing delays between RetryMin and RetryMax seconds, and readings are
+// delivered in order once the other Sink recovers.
+//
+// Up to MaxQueue readings are held in memory. When that is full readings are appended to the
+// SpoolFile, if given, otherwise the oldest readings are dropped. Anything remaining in the
+// spool file when closed is replayed the next time the sink is opened. Only the values are saved
+// to the spool file, the register details are restored from the device when they are replayed.
+type BufferedSink struct {
+	// Target The configuration of the Sink that readings are passed to, when not created using
+	// NewBufferedSink.
+	Target    SinkConfig
+	MaxQueue  int
+	SpoolFile string
+	RetryMin  float64
+	RetryMax  float64
+
+	sink        Sink
+	opened      bool
+	closeOnce   sync.Once
+	mu          sync.Mutex
+	closed      bool
+	queue       []Readings
+	spooled     int
+	spoolOffset int64
+	stats       BufferStats
+	notify      chan struct{}
+	done        chan struct{}
+	finished    chan struct{}
+}
+
+// spoolRecord The details of readings saved to the spool file.
+type spoolRecord struct {
+	Device   string
+	SlaveID  byte
+	Time     time.Time
+	Values   map[int]Value
+	Factored bool
+}
+
+func newSpoolRecord(readings Readings) spoolRecord {
+	return spoolRecord{Device: readings.Device, SlaveID: readings.SlaveID, Time: readings.Time,
+		Values: readings.Values, Factored: readings.Factored}
+}
+
+// readings Return the readings for the record, with the registers, composites and names of the
+// device if it is known.
+func (rec spoolRecord) readings() Readings {
+	readings := Readings{Device: rec.Device, SlaveID: rec.SlaveID, Time: rec.Time, Values: rec.Values,
+		Factored: rec.Factored}
+	if regs, err := RegistersByName(rec.Device); err == nil {
+		readings.Registers = regs
+		readings.Composites = compositesByName(rec.Device)
+		readings.Names = namesByName(rec.Device)
+	}
+	return readings
+}
+
+// BufferStats Details of the current state of a BufferedSink.
+type BufferStats struct {
+	// Queued The number of readings held in memory
+	Queued int
+	// Spooled The number of readings waiting in the spool file
+	Spooled int
+	Written uint64
+	Dropped uint64
+	Retries uint64
+}
+
+// NewBufferedSink Return a BufferedSink that passes readings to the supplied sink.
+func NewBufferedSink(sink Sink) *BufferedSink {
+	return &BufferedSink{sink: sink}
+}
+
+// Open Open the sink and start the background delivery of readings. If the other Sink cannot be
+// opened this is logged and retried before each delivery attempt.
+func (bs *BufferedSink) Open() error {
+	if bs.sink == nil {
+		sink, err := NewSink(bs.Target)
+		if err != nil {
+			return err
+		}
+		bs.sink = sink
+	}
+	if bs.MaxQueue <= 0 {
+		bs.MaxQueue = defaultMaxQueue
+	}
+	if bs.RetryMin <= 0 {
+		bs.RetryMin = defaultRetryMin
+	}
+	if bs.RetryMax < bs.RetryMin {
+		bs.RetryMax = defaultRetryMax
+	}
+	if bs.SpoolFile != "" {
+		n, err := countLines(bs.SpoolFile)
+		if err != nil {
+			return err
+		}
+		bs.spooled = n
+	}
+	if err := bs.sink.Open(); err != nil {
+		log.Printf("Unable to open sink, will retry: %s", err)
+	} else {
+		bs.opened = true
+	}
+
+	bs.notify = make(chan struct{}, 1)
+	bs.done = make(chan struct{})
+	bs.finished = make(chan struct{})
+	go bs.run()
+	return nil
+}
+
+// Write Queue the readings for delivery. An error is returned once the sink has been closed.
+func (bs *BufferedSink) Write(readings Readings) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if bs.closed {
+		return fmt.Errorf("Buffered sink is closed")
+	}
+	defer bs.signal()
+
+	if bs.SpoolFile != "" && (bs.spooled > 0 || len(bs.queue) >= bs.MaxQueue) {
+		if err := bs.spool(readings); err != nil {
+			bs.stats.Dropped++
+			return err
+		}
+		bs.spooled++
+		return nil
+	}
+	if len(bs.queue) >= bs.MaxQueue {
+		bs.queue = bs.queue[1:]
+		bs.stats.Dropped++
+	}
+	bs.queue = append(bs.queue, readings)
+	return nil
+}
+
+// Stats Return details of the queue and the readings written and dropped.
+func (bs *BufferedSink) Stats() BufferStats {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	stats := bs.stats
+	stats.Queued = len(bs.queue)
+	stats.Spooled = bs.spooled
+	return stats
+}
+
+// Close Stop the background delivery and make a final attempt to deliver the queued readings.
+// Any that cannot be delivered are saved to the spool file, if configured. Calling Close again
+// does nothing.
+func (bs *BufferedSink) Close() error {
+	if bs.done == nil {
+		return nil
+	}
+	var err error
+	bs.closeOnce.Do(func() {
+		bs.mu.Lock()
+		bs.closed = true
+		bs.mu.Unlock()
+		close(bs.done)
+		<-bs.finished
+		err = bs.drain()
+	})
+	return err
+}
+
+// drain Deliver the remaining readings, saving any that cannot be delivered, and close the
+// other Sink.
+func (bs *BufferedSink) drain() error {
+	for {
+		readings, ok := bs.head()
+		if !ok || bs.deliver(readings) != nil {
+			break
+		}
+		bs.pop()
+	}
+	bs.mu.Lock()
+	if len(bs.queue) > 0 {
+		if bs.SpoolFile == "" {
+			log.Printf("Unable to deliver %d readings, they have been dropped", len(bs.queue))
+		} else if err := bs.saveQueue(); err != nil {
+			log.Printf("Unable to save %d readings to %s: %s", len(bs.queue), bs.SpoolFile, err)
+		}
+	}
+	bs.mu.Unlock()
+	if !bs.opened {
+		return nil
+	}
+	return bs.sink.Close()
+}
+
+func (bs *BufferedSink) signal() {
+	select {
+	case bs.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (bs *BufferedSink) run() {
+	defer close(bs.finished)
+	backoff := bs.RetryMin
+	for {
+		readings, ok := bs.head()
+		if !ok {
+			select {
+			case <-bs.notify:
+				continue
+			case <-bs.done:
+				return
+			}
+		}
+		if err := bs.deliver(readings); err != nil {
+			log.Printf("Unable to write readings, retrying in %.1fs: %s", backoff, err)
+			bs.mu.Lock()
+			bs.stats.Retries++
+			bs.mu.Unlock()
+			select {
+			case <-time.After(time.Duration(backoff * float64(time.Second))):
+			case <-bs.done:
+				return
+			}
+			if backoff *= 2; backoff > bs.RetryMax {
+				backoff = bs.RetryMax
+			}
+			continue
+		}
+		backoff = bs.RetryMin
+		bs.pop()
+	}
+}
+
+// deliver Pass the readings to the other Sink, opening it first if required.
+func (bs *BufferedSink) deliver(readings Readings) error {
+	if !bs.opened {
+		if err := bs.sink.Open(); err != nil {
+			return err
+		}
+		bs.opened = true
+	}
+	return bs.sink.Write(readings)
+}
+
+// head Return the oldest readings, loading readings from the spool file if the memory queue
+// is empty.
+func (bs *BufferedSink) head() (Readings, bool) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if len(bs.queue) == 0 && bs.spooled > 0 {
+		if err := bs.loadSpool(); err != nil {
+			log.Printf("Unable to read spooled readings from %s: %s", bs.SpoolFile, err)
+		}
+	}
+	if len(bs.queue) == 0 {
+		return Readings{}, false
+	}
+	return bs.queue[0], true
+}
+
+func (bs *BufferedSink) pop() {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.queue = bs.queue[1:]
+	bs.stats.Written++
+}
+
+func (bs *BufferedSink) spool(readings Readings) error {
+	file, err := os.OpenFile(bs.SpoolFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewEncoder(file).Encode(newSpoolRecord(readings))
+}
+
+// loadSpool Move up to MaxQueue readings from the spool file into the memory queue. Once all
+// spooled readings have been loaded the file is truncated.
+func (bs *BufferedSink) loadSpool() error {
+	file, err := os.Open(bs.SpoolFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = file.Seek(bs.spoolOffset, io.SeekStart); err != nil {
+		return err
+	}
+
+	rdr := bufio.NewReader(file)
+	for len(bs.queue) < bs.MaxQueue && bs.spooled > 0 {
+		line, err := rdr.ReadBytes('\n')
+		if err != nil {
+			// The count of spooled readings is wrong, so start again.
+			bs.spooled = 0
+			break
+		}
+		bs.spoolOffset += int64(len(line))
+		bs.spooled--
+		var rec spoolRecord
+		if err = json.Unmarshal(line, &rec); err != nil {
+			log.Printf("Discarding invalid spooled readings: %s", err)
+			bs.stats.Dropped++
+			continue
+		}
+		bs.queue = append(bs.queue, rec.readings())
+	}
+	if bs.spooled == 0 {
+		bs.spoolOffset = 0
+		return os.Truncate(bs.SpoolFile, 0)
+	}
+	return nil
+}
+
+// saveQueue Write the memory queue to the start of the spool file, ahead of any readings still
+// waiting in the file, so they will be replayed in order.
+func (bs *BufferedSink) saveQueue() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, readings := range bs.queue {
+		if err := enc.Encode(newSpoolRecord(readings)); err != nil {
+			return err
+		}
+	}
+	if bs.spooled > 0 {
+		file, err := os.Open(bs.SpoolFile)
+		if err != nil {
+			return err
+		}
+		_, err = file.Seek(bs.spoolOffset, io.SeekStart)
+		if err == nil {
+			_, err = io.Copy(&buf, file)
+		}
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	tmpName := bs.SpoolFile + ".tmp"
+	if err := os.WriteFile(tmpName, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, bs.SpoolFile); err != nil {
+		return err
+	}
+	bs.spooled += len(bs.queue)
+	bs.spoolOffset = 0
+	bs.queue = nil
+	return nil
+}
+
+// countLines Return the number of lines in the file, or 0 if it doesn't exist.
+func countLines(filename string) (int, error) {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return bytes.Count(data, []byte{'\n'}), nil
+}
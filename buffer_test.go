@@ -0,0 +1,263 @@
+package modbusdev
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakySink A sink that fails while down is set and records the order readings are written.
+type flakySink struct {
+	mu      sync.Mutex
+	down    bool
+	devices []string
+}
+
+func (fs *flakySink) Open() error {
+	return nil
+}
+
+func (fs *flakySink) Write(readings Readings) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.down {
+		return fmt.Errorf("sink is down")
+	}
+	fs.devices = append(fs.devices, readings.Device)
+	return nil
+}
+
+func (fs *flakySink) Close() error {
+	return nil
+}
+
+func (fs *flakySink) setDown(down bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.down = down
+}
+
+func (fs *flakySink) written() []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]string{}, fs.devices...)
+}
+
+func waitForWritten(t *testing.T, fs *flakySink, n int) []string {
+	for i := 0; i < 200; i++ {
+		if written := fs.written(); len(written) >= n {
+			return written
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d readings to be written, have %d", n, len(fs.written()))
+	return nil
+}
+
+func checkOrder(t *testing.T, written []string, expected int) {
+	if len(written) != expected {
+		t.Fatalf("Expected %d readings written, got %d", expected, len(written))
+	}
+	for i, dev := range written {
+		if dev != fmt.Sprintf("dev%d", i) {
+			t.Fatalf("Readings written out of order: %v", written)
+		}
+	}
+}
+
+func TestBufferedSinkRetry(t *testing.T) {
+	target := &flakySink{down: true}
+	bs := NewBufferedSink(target)
+	bs.RetryMin = 0.01
+	bs.RetryMax = 0.02
+	bs.MaxQueue = 3
+	if err := bs.Open(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := bs.Write(Readings{Device: fmt.Sprintf("dev%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	stats := bs.Stats()
+	if stats.Queued != 3 || stats.Dropped != 2 || stats.Retries == 0 {
+		t.Fatalf("Incorrect stats while sink is down: %+v", stats)
+	}
+	target.setDown(false)
+	written := waitForWritten(t, target, 3)
+	if len(written) != 3 || written[0] != "dev2" || written[2] != "dev4" {
+		t.Fatalf("Incorrect readings written: %v", written)
+	}
+	bs.Close()
+}
+
+func TestBufferedSinkSpool(t *testing.T) {
+	spoolFile := filepath.Join(t.TempDir(), "spool.jsonl")
+	target := &flakySink{down: true}
+	bs := NewBufferedSink(target)
+	bs.RetryMin = 0.01
+	bs.RetryMax = 0.02
+	bs.MaxQueue = 2
+	bs.SpoolFile = spoolFile
+	if err := bs.Open(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		bs.Write(Readings{Device: fmt.Sprintf("dev%d", i)})
+	}
+	if stats := bs.Stats(); stats.Queued != 2 || stats.Spooled != 3 || stats.Dropped != 0 {
+		t.Fatalf("Incorrect stats after spooling: %+v", stats)
+	}
+	// Closing while the sink is down should save everything to the spool file
+	bs.Close()
+	if n, _ := countLines(spoolFile); n != 5 {
+		t.Fatalf("Expected 5 spooled readings after close, got %d", n)
+	}
+
+	target.setDown(false)
+	bs = NewBufferedSink(target)
+	bs.RetryMin = 0.01
+	bs.MaxQueue = 2
+	bs.SpoolFile = spoolFile
+	if err := bs.Open(); err != nil {
+		t.Fatal(err)
+	}
+	bs.Write(Readings{Device: "dev5"})
+	checkOrder(t, waitForWritten(t, target, 6), 6)
+	if err := bs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := countLines(spoolFile); n != 0 {
+		t.Fatalf("Expected empty spool file, got %d lines", n)
+	}
+	if err := bs.Close(); err != nil {
+		t.Fatalf("Unexpected error closing twice: %s", err)
+	}
+	if err := bs.Write(Readings{Device: "dev6"}); err == nil {
+		t.Fatal("Expected error writing after close")
+	}
+}
+
+func TestBufferedSinkSpoolRecord(t *testing.T) {
+	spoolFile := filepath.Join(t.TempDir(), "spool.jsonl")
+	regs, _ := RegistersByName("solaxx1hybrid")
+	bs := &BufferedSink{SpoolFile: spoolFile, MaxQueue: 1}
+	bs.queue = []Readings{{Device: "solaxx1hybrid", Registers: regs, Composites: compositesByName("solaxx1hybrid"),
+		Values: map[int]Value{30011: {Unsigned16: 1500}}}}
+	if err := bs.saveQueue(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(spoolFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "Description") {
+		t.Fatalf("Register details should not be spooled: %s", data)
+	}
+	if err = bs.loadSpool(); err != nil {
+		t.Fatal(err)
+	}
+	readings := bs.queue[0]
+	if readings.Name(30011) != "pv1_power" || readings.FactoredValue(30011) != 1500 {
+		t.Fatalf("Incorrect readings loaded from spool: %s %f", readings.Name(30011), readings.FactoredValue(30011))
+	}
+}
+
+func TestBufferedSinkReconnect(t *testing.T) {
+	defer func(orig string) { postgresDriver = orig }(postgresDriver)
+	postgresDriver = "modbusdev-test"
+	testDriver.setDown(false)
+	start := len(testDriver.inserted())
+	waitForRows := func(n int) {
+		for i := 0; i < 200 && len(testDriver.inserted())-start < n; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := len(testDriver.inserted()) - start; got != n {
+			t.Fatalf("Incorrect number of rows inserted. Got %d expected %d", got, n)
+		}
+	}
+
+	dbC := &DatabaseConnection{Device: "solaxx1hybrid", Query: "INSERT INTO solax (time%s) VALUES (NOW()%s)",
+		Fields: []DatabaseField{{Name: "pv1", Code: 30011, Type: "int"}}}
+	bs := NewBufferedSink(dbC)
+	bs.RetryMin = 0.01
+	bs.RetryMax = 0.02
+	bs.MaxQueue = 1
+	bs.SpoolFile = filepath.Join(t.TempDir(), "spool.jsonl")
+	if err := bs.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+	regs, _ := RegistersByName("solaxx1hybrid")
+	write := func(pv1 uint16) {
+		if err := bs.Write(Readings{Device: "solaxx1hybrid", Registers: regs,
+			Values: map[int]Value{30011: {Unsigned16: pv1}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(1000)
+	waitForRows(1)
+
+	// While the database is down the readings are queued, then spooled
+	testDriver.setDown(true)
+	write(1100)
+	write(1200)
+	for i := 0; i < 200 && bs.Stats().Retries == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := bs.Stats(); stats.Retries == 0 || stats.Spooled == 0 {
+		t.Fatalf("Incorrect stats while the database is down: %+v", stats)
+	}
+
+	testDriver.setDown(false)
+	waitForRows(3)
+	for i, row := range testDriver.inserted()[start:] {
+		if expected := int64(1000 + 100*i); row[0] != expected {
+			t.Fatalf("Incorrect value inserted. Got %v expected %d", row[0], expected)
+		}
+	}
+}
+
+func TestBufferedSinkReplayTime(t *testing.T) {
+	defer func(orig string) { postgresDriver = orig }(postgresDriver)
+	postgresDriver = "modbusdev-test"
+	testDriver.setDown(false)
+	start := len(testDriver.inserted())
+
+	// A reading spooled during an earlier outage
+	spoolFile := filepath.Join(t.TempDir(), "spool.jsonl")
+	read := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	spooled := &BufferedSink{SpoolFile: spoolFile}
+	spooled.queue = []Readings{{Device: "solaxx1hybrid", Time: read, Values: map[int]Value{30011: {Unsigned16: 1500}}}}
+	if err := spooled.saveQueue(); err != nil {
+		t.Fatal(err)
+	}
+
+	dbC := &DatabaseConnection{Device: "solaxx1hybrid", Table: "solax", CreateTable: true,
+		Fields: []DatabaseField{{Name: "pv1", Code: 30011}}}
+	bs := NewBufferedSink(dbC)
+	bs.RetryMin = 0.01
+	bs.SpoolFile = spoolFile
+	if err := bs.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+	for i := 0; i < 200 && len(testDriver.inserted()) == start; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rows := testDriver.inserted()[start:]
+	if len(rows) != 1 {
+		t.Fatalf("Incorrect number of rows inserted. Got %d expected 1", len(rows))
+	}
+	if tm, ck := rows[0][0].(time.Time); !ck || !tm.Equal(read) {
+		t.Fatalf("Incorrect time inserted. Got %v expected %s", rows[0][0], read)
+	}
+	if rows[0][1] != int64(1500) {
+		t.Fatalf("Incorrect value inserted. Got %v expected 1500", rows[0][1])
+	}
+}
@@ -0,0 +1,164 @@
+package modbusdev
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DateTimeRegisters Details of the registers a device uses to hold its clock. Each part is the
+// Modicon code of a u16 register, with 0 used for any part the device does not provide.
+type DateTimeRegisters struct {
+	Year   int
+	Month  int
+	Day    int
+	Hour   int
+	Minute int
+	Second int
+	// ShortYear Set if the year is stored without the century, e.g. 21 for 2021
+	ShortYear bool
+}
+
+func (dtr DateTimeRegisters) codes() []int {
+	return []int{dtr.Year, dtr.Month, dtr.Day, dtr.Hour, dtr.Minute, dtr.Second}
+}
+
+// composite Return a datetime Composite that gives the value of the clock.
+func (dtr DateTimeRegisters) composite(description, name string) Composite {
+	var codes []int
+	for _, code := range dtr.codes() {
+		if code != 0 {
+			codes = append(codes, code)
+		}
+	}
+	return Composite{Description: description, Codes: codes, Format: "datetime", Name: name,
+		ShortYear: dtr.ShortYear}
+}
+
+// toTime Given the values for each part, in the order returned by codes(), return the time.
+// Device clocks are assumed to be in local time.
+func (dtr DateTimeRegisters) toTime(parts []int) time.Time {
+	year := parts[0]
+	if dtr.ShortYear {
+		year += 2000
+	}
+	return time.Date(year, time.Month(parts[1]), parts[2], parts[3], parts[4], parts[5], 0, time.Local)
+}
+
+func (dtr DateTimeRegisters) fromTime(tm time.Time) []uint16 {
+	year := tm.Year()
+	if dtr.ShortYear {
+		year %= 100
+	}
+	return []uint16{uint16(year), uint16(tm.Month()), uint16(tm.Day()),
+		uint16(tm.Hour()), uint16(tm.Minute()), uint16(tm.Second())}
+}
+
+// span Return the first register address and quantity of registers that need to be read to
+// obtain all parts of the clock.
+func (dtr DateTimeRegisters) span(registers map[int]Register) (start, qty uint16, err error) {
+	start = 65535
+	var end uint16
+	for _, code := range dtr.codes() {
+		if code == 0 {
+			continue
+		}
+		reg, ck := registers[code]
+		if !ck {
+			return 0, 0, fmt.Errorf("Clock register %d is not available", code)
+		}
+		if reg.Register < start {
+			start = reg.Register
+		}
+		if reg.maxRegister() > end {
+			end = reg.maxRegister()
+		}
+	}
+	if end == 0 {
+		return 0, 0, fmt.Errorf("No clock registers configured")
+	}
+	return start, end - start, nil
+}
+
+// ReadTime Read the device clock. All parts are read in a single request.
+func (rdr *Reader) ReadTime() (tm time.Time, err error) {
+	clock, ck := clockByName(rdr.device)
+	if !ck {
+		return tm, fmt.Errorf("Device '%s' does not have a clock", rdr.device)
+	}
+	start, qty, err := clock.span(rdr.registers)
+	if err != nil {
+		return
+	}
+	var results []byte
+	switch getRegisterType(clock.Year) {
+	case 3:
+		results, err = rdr.client.ReadInputRegisters(start, qty)
+	case 4:
+		results, err = rdr.client.ReadHoldingRegisters(start, qty)
+	}
+	if err != nil {
+		return
+	}
+	if len(results) < int(qty)*2 {
+		return tm, fmt.Errorf("Short response reading clock, %d bytes vs expected %d", len(results), qty*2)
+	}
+
+	parts := make([]int, 6)
+	for i, code := range clock.codes() {
+		if code == 0 {
+			continue
+		}
+		offset := (rdr.registers[code].Register - start) * 2
+		parts[i] = int(unsigned16(results[offset:]))
+	}
+	return clock.toTime(parts), nil
+}
+
+// SyncClock Set the device clock to the supplied time. All parts are written in a single request,
+// so the registers used for writing must be consecutive.
+func (wrt *Writer) SyncClock(tm time.Time) error {
+	clock, ck := clockByName(wrt.device)
+	if !ck {
+		return fmt.Errorf("Device '%s' does not have a clock", wrt.device)
+	}
+
+	type clockPart struct {
+		code    int
+		reg     Register
+		address uint16
+		value   uint16
+	}
+	var parts []clockPart
+	values := clock.fromTime(tm)
+	for i, code := range clock.codes() {
+		if code == 0 {
+			continue
+		}
+		reg, ck := wrt.registers[code]
+		if !ck {
+			return fmt.Errorf("Clock register %d is not available", code)
+		}
+		parts = append(parts, clockPart{code, reg, wrt.writeAddress(code, reg), values[i]})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].address < parts[j].address })
+
+	byts := make([]byte, len(parts)*2)
+	for i, part := range parts {
+		if part.address != parts[0].address+uint16(i) {
+			return fmt.Errorf("Clock registers are not consecutive, unable to write as a single request")
+		}
+		byts[i*2] = byte(part.value >> 8)
+		byts[i*2+1] = byte(part.value)
+	}
+
+	previous := make([]Value, len(parts))
+	for i, part := range parts {
+		previous[i] = wrt.auditPrevious(part.reg)
+	}
+	_, err := wrt.client.WriteMultipleRegisters(parts[0].address, uint16(len(parts)), byts)
+	for i, part := range parts {
+		wrt.auditWrite(part.code, part.reg, part.address, previous[i], byts[i*2:i*2+2], err)
+	}
+	return err
+}
@@ -0,0 +1,49 @@
+package modbusdev
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadTime(t *testing.T) {
+	client := NewFakeClient()
+	client.Holding[0x86] = 45
+	client.Holding[0x87] = 13
+	client.Holding[0x88] = 2
+	client.Holding[0x89] = 3
+	client.Holding[0x8A] = 21
+	rdr, err := NewReader(client, "solaxx1hybridex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm, err := rdr.ReadTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := time.Date(2021, 3, 2, 13, 45, 0, 0, time.Local)
+	if !tm.Equal(expected) {
+		t.Fatalf("Incorrect time read. Got %s expected %s", tm, expected)
+	}
+
+	rdr, _ = NewReader(client, "sdm230")
+	if _, err := rdr.ReadTime(); err == nil {
+		t.Fatal("Expected error reading time from device without a clock")
+	}
+}
+
+func TestSyncClock(t *testing.T) {
+	client := NewFakeClient()
+	wrt, err := NewWriter(client, "solaxx1hybridex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wrt.SyncClock(time.Date(2021, 11, 28, 7, 9, 0, 0, time.Local)); err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint16{9, 7, 28, 11, 21}
+	for i, exp := range expected {
+		if v := client.Holding[uint16(i+1)]; v != exp {
+			t.Fatalf("Incorrect value for clock register %d. Got %d expected %d", i+1, v, exp)
+		}
+	}
+}
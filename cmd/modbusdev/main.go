@@ -0,0 +1,346 @@
+// Command modbusdev provides ad-hoc access to the devices supported by the modbusdev package.
+//
+//	modbusdev [flags] list-devices
+//	modbusdev [flags] dump
+//	modbusdev [flags] read <code|name>...
+//	modbusdev [flags] write <code|name> <value>
+//	modbusdev [flags] scan <start> <stop>
+//	modbusdev -config <file> poll
+//	modbusdev [-config <file>] [-device <name>] [-tcp <address>] simulate
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/zathras777/modbusdev"
+)
+
+// options The connection and output settings given on the command line.
+type options struct {
+	tcp      string
+	serial   string
+	baudRate int
+	dataBits int
+	parity   string
+	stopBits int
+	slaveID  int
+	timeout  time.Duration
+	device   string
+	format   string
+	input    bool
+	config   string
+}
+
+// connect Return a client for the device, along with a function to close the connection.
+// Replaced when testing.
+var connect = func(opts options) (modbus.Client, func() error, error) {
+	if opts.tcp == "" && opts.serial == "" {
+		return nil, nil, fmt.Errorf("Either -tcp or -serial must be given")
+	}
+	cc := modbusdev.ConnectionConfig{TCP: opts.tcp, Serial: opts.serial, BaudRate: opts.baudRate,
+		DataBits: opts.dataBits, Parity: opts.parity, StopBits: opts.stopBits, Timeout: opts.timeout.Seconds()}
+	return cc.Connect(byte(opts.slaveID))
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	var opts options
+	flags := flag.NewFlagSet("modbusdev", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&opts.tcp, "tcp", "", "Address of a Modbus TCP device, host:port")
+	flags.StringVar(&opts.serial, "serial", "", "Serial port of a Modbus RTU device, e.g. /dev/ttyUSB0")
+	flags.IntVar(&opts.baudRate, "baud", 9600, "Serial baud rate")
+	flags.IntVar(&opts.dataBits, "databits", 8, "Serial data bits")
+	flags.StringVar(&opts.parity, "parity", "N", "Serial parity, N, E or O")
+	flags.IntVar(&opts.stopBits, "stopbits", 1, "Serial stop bits")
+	flags.IntVar(&opts.slaveID, "slave", 1, "Slave ID of the device")
+	flags.DurationVar(&opts.timeout, "timeout", 5*time.Second, "Time to wait for a response")
+	flags.StringVar(&opts.device, "device", "", "Device name, one of "+strings.Join(modbusdev.Devices(), ", "))
+	flags.StringVar(&opts.format, "format", "table", "Output format, one of "+strings.Join(modbusdev.DumpFormats, ", "))
+	flags.BoolVar(&opts.input, "input", false, "Scan input rather than holding registers")
+	flags.StringVar(&opts.config, "config", "", "Poller or simulator configuration file, JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: modbusdev [flags] <command> [arguments]")
+		fmt.Fprintln(stderr, "\nCommands:")
+		fmt.Fprintln(stderr, "  list-devices                 List the devices that are known")
+		fmt.Fprintln(stderr, "  dump                         Read and show all registers")
+		fmt.Fprintln(stderr, "  read <code|name>...          Read and show the given registers")
+		fmt.Fprintln(stderr, "  write <code|name> <value>    Write a value, with the factor applied, to a register")
+		fmt.Fprintln(stderr, "  scan <start> <stop>          Show the raw contents of a range of registers")
+		fmt.Fprintln(stderr, "  poll                         Poll the devices in the -config file until stopped")
+		fmt.Fprintln(stderr, "  simulate                     Serve the device registers as a Modbus TCP slave on -tcp")
+		fmt.Fprintln(stderr, "\nFlags:")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("No command given")
+	}
+	if opts.slaveID < 0 || opts.slaveID > 247 {
+		return fmt.Errorf("Invalid slave ID %d", opts.slaveID)
+	}
+	command, cmdArgs := flags.Arg(0), flags.Args()[1:]
+
+	switch command {
+	case "list-devices":
+		for _, device := range modbusdev.Devices() {
+			fmt.Fprintln(stdout, device)
+		}
+		return nil
+	case "poll":
+		if opts.config == "" {
+			return fmt.Errorf("A configuration file must be given using -config")
+		}
+		return poll(opts.config)
+	case "simulate":
+		return simulate(opts, stderr)
+	case "dump", "read", "write", "scan":
+	default:
+		flags.Usage()
+		return fmt.Errorf("Unknown command '%s'", command)
+	}
+	if err := checkArgs(command, cmdArgs); err != nil {
+		return err
+	}
+	if opts.device == "" && command != "scan" {
+		return fmt.Errorf("A device must be given using -device")
+	}
+
+	client, closer, err := connect(opts)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	if command == "scan" {
+		return scan(client, stdout, opts, cmdArgs)
+	}
+	rdr, err := modbusdev.NewReader(client, opts.device)
+	if err != nil {
+		return err
+	}
+	rdr.SetSlaveID(byte(opts.slaveID))
+
+	switch command {
+	case "dump":
+		return rdr.Dump(stdout, opts.format)
+	case "read":
+		return read(&rdr, stdout, opts.format, cmdArgs)
+	}
+	return write(client, &rdr, stdout, opts, cmdArgs)
+}
+
+// checkArgs Check the number of arguments given for the command.
+func checkArgs(command string, args []string) error {
+	expected := map[string]int{"dump": 0, "write": 2, "scan": 2}
+	if command == "read" {
+		if len(args) == 0 {
+			return fmt.Errorf("read requires at least one register code or name")
+		}
+		return nil
+	}
+	if len(args) != expected[command] {
+		return fmt.Errorf("%s requires %d arguments, %d given", command, expected[command], len(args))
+	}
+	return nil
+}
+
+func read(rdr *modbusdev.Reader, stdout io.Writer, format string, names []string) error {
+	var codes []int
+	for _, name := range names {
+		code, err := rdr.Code(name)
+		if err != nil {
+			return err
+		}
+		codes = append(codes, code)
+	}
+	readings, err := rdr.Readings(true)
+	if err != nil {
+		return err
+	}
+	return readings.Format(stdout, format, codes...)
+}
+
+func write(client modbus.Client, rdr *modbusdev.Reader, stdout io.Writer, opts options, args []string) error {
+	code, err := rdr.Code(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return fmt.Errorf("Invalid value '%s'", args[1])
+	}
+	wrt, err := modbusdev.NewWriter(client, opts.device)
+	if err != nil {
+		return err
+	}
+	if err = wrt.WriteFactored(code, value); err != nil {
+		return err
+	}
+	return read(rdr, stdout, opts.format, args[:1])
+}
+
+func scan(client modbus.Client, stdout io.Writer, opts options, args []string) error {
+	var limits [2]uint16
+	for i, arg := range args {
+		val, err := strconv.ParseUint(arg, 0, 16)
+		if err != nil {
+			return fmt.Errorf("Invalid register '%s'", arg)
+		}
+		limits[i] = uint16(val)
+	}
+	if limits[1] < limits[0] || limits[1]-limits[0] >= 125 {
+		return fmt.Errorf("The stop register must be after the start and at most 125 registers can be scanned")
+	}
+	read := client.ReadHoldingRegisters
+	if opts.input {
+		read = client.ReadInputRegisters
+	}
+	qty := limits[1] - limits[0] + 1
+	results, err := read(limits[0], qty)
+	if err != nil {
+		return fmt.Errorf("Unable to read registers %d to %d: %s", limits[0], limits[1], err)
+	}
+	if len(results) < int(qty)*2 {
+		return fmt.Errorf("Short response, %d bytes received", len(results))
+	}
+	for n := uint16(0); n < qty; n++ {
+		reg := limits[0] + n
+		val := binary.BigEndian.Uint16(results[n*2:])
+		fmt.Fprintf(stdout, "Register %d [%04X] : %X [%d]\n", reg, reg, val, val)
+	}
+	return nil
+}
+
+// poll Run a Poller until SIGINT or SIGTERM is received. On SIGHUP the configuration is
+// reloaded and the Poller restarted, unless the new configuration is invalid in which case
+// polling continues with the existing one.
+func poll(filename string) error {
+	cfg, err := modbusdev.LoadPollerConfig(filename)
+	if err != nil {
+		return err
+	}
+	pl, err := modbusdev.NewPoller(cfg)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	var mu sync.Mutex
+	if cfg.HealthAddress != "" {
+		srv := &http.Server{Addr: cfg.HealthAddress, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			current := pl
+			mu.Unlock()
+			current.ServeHTTP(w, r)
+		})}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Health server stopped: %s", err)
+			}
+		}()
+		defer srv.Close()
+	}
+
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func(pl *modbusdev.Poller) { done <- pl.Run(ctx) }(pl)
+
+		var next *modbusdev.Poller
+		for next == nil {
+			select {
+			case err := <-done:
+				cancel()
+				return err
+			case sig := <-signals:
+				if sig != syscall.SIGHUP {
+					log.Printf("Received %s, stopping", sig)
+					cancel()
+					return <-done
+				}
+				cfg, err := modbusdev.LoadPollerConfig(filename)
+				if err == nil {
+					next, err = modbusdev.NewPoller(cfg)
+				}
+				if err != nil {
+					log.Printf("Unable to reload configuration, continuing with the existing one: %s", err)
+				}
+			}
+		}
+		log.Printf("Configuration reloaded, restarting")
+		cancel()
+		if err := <-done; err != nil {
+			log.Printf("Poller stopped with error: %s", err)
+		}
+		mu.Lock()
+		pl = next
+		mu.Unlock()
+	}
+}
+
+// simulate Run a Simulator until SIGINT or SIGTERM is received. The -device and -tcp flags
+// override the device and address in the configuration file.
+func simulate(opts options, stderr io.Writer) error {
+	var cfg modbusdev.SimulatorConfig
+	if opts.config != "" {
+		data, err := os.ReadFile(opts.config)
+		if err != nil {
+			return err
+		}
+		if err = json.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("Unable to parse %s: %s", opts.config, err)
+		}
+	}
+	if opts.device != "" {
+		cfg.Device = opts.device
+	}
+	if opts.tcp != "" {
+		cfg.Address = opts.tcp
+	}
+	if cfg.Device == "" {
+		return fmt.Errorf("A device must be given using -device or in the -config file")
+	}
+	sim, err := modbusdev.NewSimulator(cfg)
+	if err != nil {
+		return err
+	}
+	if err = sim.Open(); err != nil {
+		return err
+	}
+	defer sim.Close()
+	fmt.Fprintf(stderr, "Simulating %s on %s\n", cfg.Device, sim.Addr())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	<-signals
+	return nil
+}
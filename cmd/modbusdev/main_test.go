@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/goburrow/modbus"
+	"github.com/zathras777/modbusdev"
+)
+
+func TestListDevices(t *testing.T) {
+	var stdout bytes.Buffer
+	if err := run([]string{"list-devices"}, &stdout, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "solaxx1hybrid\n") {
+		t.Fatalf("Incorrect output %s", stdout.String())
+	}
+}
+
+func TestArguments(t *testing.T) {
+	defer func(orig func(options) (modbus.Client, func() error, error)) { connect = orig }(connect)
+	connect = func(opts options) (modbus.Client, func() error, error) {
+		t.Fatal("Connection should not be attempted")
+		return nil, nil, nil
+	}
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{}, "No command given"},
+		{[]string{"fetch"}, "Unknown command 'fetch'"},
+		{[]string{"-slave", "300", "dump"}, "Invalid slave ID 300"},
+		{[]string{"-tcp", "localhost:502", "dump"}, "A device must be given using -device"},
+		{[]string{"-device", "sdm230", "read"}, "read requires at least one register code or name"},
+		{[]string{"-device", "sdm230", "write", "30001"}, "write requires 2 arguments, 1 given"},
+		{[]string{"scan", "1"}, "scan requires 2 arguments, 1 given"},
+		{[]string{"poll"}, "A configuration file must be given using -config"},
+		{[]string{"simulate"}, "A device must be given using -device or in the -config file"},
+	}
+	for _, tst := range tests {
+		err := run(tst.args, io.Discard, io.Discard)
+		if err == nil || err.Error() != tst.err {
+			t.Fatalf("Incorrect error for %v. Got %v expected %s", tst.args, err, tst.err)
+		}
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	client := modbusdev.NewFakeClient()
+	client.SetInput(0x00, 2405)
+	client.SetHolding(0xB6, 3000)
+	defer func(orig func(options) (modbus.Client, func() error, error)) { connect = orig }(connect)
+	connect = func(opts options) (modbus.Client, func() error, error) {
+		return client, func() error { return nil }, nil
+	}
+
+	var stdout bytes.Buffer
+	if err := run([]string{"-device", "solaxx1hybridex", "-format", "csv", "read", "grid_voltage"}, &stdout, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "30001,grid_voltage,Grid Voltage,2405,240.5,V\n") {
+		t.Fatalf("Incorrect output %s", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := run([]string{"-device", "solaxx1hybridex", "-format", "csv", "write", "max_export_power", "2500"}, &stdout, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if client.GetHolding(0x42) != 2500 || !strings.Contains(stdout.String(), "40183,max_export_power") {
+		t.Fatalf("Incorrect write. Got %d and output %s", client.GetHolding(0x42), stdout.String())
+	}
+
+	stdout.Reset()
+	if err := run([]string{"-input", "scan", "0", "1"}, &stdout, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "Register 0 [0000] : 965 [2405]\nRegister 1 [0001] : 0 [0]\n" {
+		t.Fatalf("Incorrect output %s", stdout.String())
+	}
+
+	client.AddFault(modbusdev.FakeFault{Function: modbus.FuncCodeReadHoldingRegisters, Short: true})
+	err := run([]string{"scan", "0", "1"}, io.Discard, io.Discard)
+	if err == nil || err.Error() != "Short response, 3 bytes received" {
+		t.Fatalf("Incorrect error. Got %v", err)
+	}
+}
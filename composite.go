@@ -0,0 +1,88 @@
+package modbusdev
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Composite A value that is derived from several u16 registers, such as a MAC address that is
+// spread over 3 registers. Composites are given codes starting with 9 (e.g. 940163) so that they
+// cannot clash with the Modicon numbering used for registers.
+//
+// Supported formats are
+//
+//	mac      each register provides 2 bytes of the address
+//	ip       4 registers each provide an octet, otherwise 2 registers provide 2 octets each
+//	version  register values joined with '.', e.g. 1.2.3
+//	date     year, month & day registers
+//	datetime year, month, day, hour, minute & (optionally) second registers
+//
+// Dates and times are decoded in the same way as the device clock, see DateTimeRegisters.
+type Composite struct {
+	Description string
+	Codes       []int
+	Format      string
+	Name        string
+	// ShortYear Set if a date or datetime year is stored without the century.
+	ShortYear bool
+}
+
+func isComposite(code int) bool {
+	return getRegisterType(code) == 9
+}
+
+// format Given the values of the registers, in the order they are listed in Codes, return the
+// formatted string.
+func (c Composite) format(vals []uint16) (string, error) {
+	if len(vals) != len(c.Codes) {
+		return "", fmt.Errorf("Expected %d values for %s, got %d", len(c.Codes), c.Description, len(vals))
+	}
+	var parts []string
+	switch c.Format {
+	case "mac":
+		for _, v := range vals {
+			parts = append(parts, fmt.Sprintf("%02X:%02X", v>>8, v&0xff))
+		}
+		return strings.Join(parts, ":"), nil
+	case "ip":
+		switch len(vals) {
+		case 4:
+			for _, v := range vals {
+				parts = append(parts, fmt.Sprintf("%d", v&0xff))
+			}
+		case 2:
+			for _, v := range vals {
+				parts = append(parts, fmt.Sprintf("%d.%d", v>>8, v&0xff))
+			}
+		default:
+			return "", fmt.Errorf("IP address requires 2 or 4 registers, not %d", len(vals))
+		}
+		return strings.Join(parts, "."), nil
+	case "version":
+		for _, v := range vals {
+			parts = append(parts, fmt.Sprintf("%d", v))
+		}
+		return strings.Join(parts, "."), nil
+	case "date":
+		if len(vals) != 3 {
+			return "", fmt.Errorf("Date requires 3 registers, not %d", len(vals))
+		}
+		return c.time(vals).Format("2006-01-02"), nil
+	case "datetime":
+		if len(vals) < 5 || len(vals) > 6 {
+			return "", fmt.Errorf("Date & time requires 5 or 6 registers, not %d", len(vals))
+		}
+		return c.time(vals).Format("2006-01-02 15:04:05"), nil
+	}
+	return "", fmt.Errorf("Unknown composite format '%s'", c.Format)
+}
+
+// time Return the time given by the year, month, day... values supplied.
+func (c Composite) time(vals []uint16) time.Time {
+	parts := make([]int, 6)
+	for i, v := range vals {
+		parts[i] = int(v)
+	}
+	return DateTimeRegisters{ShortYear: c.ShortYear}.toTime(parts)
+}
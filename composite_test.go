@@ -0,0 +1,79 @@
+package modbusdev
+
+import (
+	"testing"
+)
+
+func TestCompositeFormat(t *testing.T) {
+	tests := []struct {
+		comp     Composite
+		vals     []uint16
+		expected string
+	}{
+		{Composite{Description: "MAC", Codes: []int{1, 2, 3}, Format: "mac", Name: "test"}, []uint16{0x0012, 0x34AB, 0xCDEF}, "00:12:34:AB:CD:EF"},
+		{Composite{Description: "IP", Codes: []int{1, 2}, Format: "ip", Name: "test"}, []uint16{0xC0A8, 0x0164}, "192.168.1.100"},
+		{Composite{Description: "IP", Codes: []int{1, 2, 3, 4}, Format: "ip", Name: "test"}, []uint16{10, 0, 0, 1}, "10.0.0.1"},
+		{Composite{Description: "Version", Codes: []int{1, 2, 3}, Format: "version", Name: "test"}, []uint16{1, 12, 3}, "1.12.3"},
+		{Composite{Description: "Date", Codes: []int{1, 2, 3}, Format: "date", Name: "test", ShortYear: true}, []uint16{21, 2, 15}, "2021-02-15"},
+		{Composite{Description: "Date", Codes: []int{1, 2, 3, 4, 5}, Format: "datetime", Name: "test"}, []uint16{2021, 2, 15, 8, 5}, "2021-02-15 08:05:00"},
+	}
+	for _, tst := range tests {
+		s, err := tst.comp.format(tst.vals)
+		if err != nil {
+			t.Fatalf("Unexpected error formatting %s: %s", tst.comp.Format, err)
+		}
+		if s != tst.expected {
+			t.Fatalf("Incorrect %s value. Got %s expected %s", tst.comp.Format, s, tst.expected)
+		}
+	}
+	if _, err := (Composite{Description: "IP", Codes: []int{1, 2, 3}, Format: "ip", Name: "test"}).format([]uint16{1, 2, 3}); err == nil {
+		t.Fatal("Expected error for IP address with 3 registers")
+	}
+}
+
+func TestReaderComposite(t *testing.T) {
+	client := NewFakeClient()
+	client.Holding[0xA2] = 0x0012
+	client.Holding[0xA3] = 0x34AB
+	client.Holding[0xA4] = 0xCDEF
+	rdr, err := NewReader(client, "solaxx1hybridex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rdr.Read(); err != nil {
+		t.Fatal(err)
+	}
+	val, err := rdr.Get(940163, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.Text != "00:12:34:AB:CD:EF" {
+		t.Fatalf("Incorrect MAC address. Got %s", val.Text)
+	}
+	if mapped := rdr.Map(false); mapped[940163].Text != val.Text {
+		t.Fatalf("MAC address missing from Map(). Got '%s'", mapped[940163].Text)
+	}
+}
+
+func TestReaderCompositeClock(t *testing.T) {
+	client := NewFakeClient()
+	client.SetHolding(0x86, 5, 8, 15, 2, 21)
+	rdr, err := NewReader(client, "solaxx1hybridex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm, err := rdr.ReadTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rdr.Read(); err != nil {
+		t.Fatal(err)
+	}
+	val, err := rdr.Get(940135, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := tm.Format("2006-01-02 15:04:05"); val.Text != expected || expected != "2021-02-15 08:05:00" {
+		t.Fatalf("Incorrect value. Got %s expected %s", val.Text, expected)
+	}
+}
@@ -0,0 +1,85 @@
+package modbusdev
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+const defaultTimeout = 5
+
+// ConnectionConfig Details of a Modbus TCP or RTU (serial) connection.
+type ConnectionConfig struct {
+	Name string
+	// TCP The host:port of a Modbus TCP device
+	TCP string
+	// Serial The serial port of a Modbus RTU device, e.g. /dev/ttyUSB0
+	Serial   string
+	BaudRate int
+	DataBits int
+	Parity   string
+	StopBits int
+	// Timeout Seconds to wait for a response, 5 if not set.
+	Timeout float64
+}
+
+// modbusConnection A connected client. As several devices may share a connection, the lock
+// must be held while the slave ID is set and the device is accessed.
+type modbusConnection struct {
+	mu       sync.Mutex
+	client   modbus.Client
+	setSlave func(id byte)
+	close    func() error
+}
+
+// dial Open the connection, replaced when testing. If the connection cannot be opened the
+// connection is still returned along with the error, as the client will try again when it is
+// next used.
+var dial = func(cc ConnectionConfig) (*modbusConnection, error) {
+	timeout := time.Duration(cc.Timeout * float64(time.Second))
+	if timeout <= 0 {
+		timeout = defaultTimeout * time.Second
+	}
+	switch {
+	case cc.TCP != "":
+		handler := modbus.NewTCPClientHandler(cc.TCP)
+		handler.Timeout = timeout
+		return &modbusConnection{client: modbus.NewClient(handler),
+			setSlave: func(id byte) { handler.SlaveId = id }, close: handler.Close}, handler.Connect()
+	case cc.Serial != "":
+		handler := modbus.NewRTUClientHandler(cc.Serial)
+		if cc.BaudRate > 0 {
+			handler.BaudRate = cc.BaudRate
+		}
+		if cc.DataBits > 0 {
+			handler.DataBits = cc.DataBits
+		}
+		if cc.Parity != "" {
+			handler.Parity = strings.ToUpper(cc.Parity)
+		}
+		if cc.StopBits > 0 {
+			handler.StopBits = cc.StopBits
+		}
+		handler.Timeout = timeout
+		return &modbusConnection{client: modbus.NewClient(handler),
+			setSlave: func(id byte) { handler.SlaveId = id }, close: handler.Close}, handler.Connect()
+	}
+	return nil, fmt.Errorf("Connection %s must have either TCP or Serial set", cc.Name)
+}
+
+// Connect Open the connection and return a client for the given slave, along with a function
+// to close the connection.
+func (cc ConnectionConfig) Connect(slaveID byte) (modbus.Client, func() error, error) {
+	conn, err := dial(cc)
+	if err != nil {
+		if conn != nil {
+			conn.close()
+		}
+		return nil, nil, err
+	}
+	conn.setSlave(slaveID)
+	return conn.client, conn.close, nil
+}
@@ -0,0 +1,330 @@
+package modbusdev
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// CSVSink A Sink that appends readings to CSV files, with a row per reading containing the time
+// and a column for each register. The header is generated from the register descriptions and
+// units.
+//
+// A new file, Prefix-YYYY-MM-DD.csv, is started each day. If MaxSize is set, a file that grows
+// beyond that many bytes is renamed with the time added and a new file started. Older files are
+// compressed with gzip if Compress is set.
+//
+// Readings from each device are written to separate files, named using the device unless Prefix
+// is set. Set Device to only write the readings of one device, which is required to use a Prefix
+// when the sink is shared by several devices.
+type CSVSink struct {
+	Directory string
+	// Prefix The start of each filename, the device name if not set.
+	Prefix string
+	// Device If set, readings from other devices are ignored.
+	Device string
+	// Registers The names (or codes) of the registers to include. All registers and composites
+	// are included if none are given.
+	Registers []string
+	MaxSize   int64
+	Compress  bool
+	// TimeFormat The layout used for the time column, RFC 3339 if not set.
+	TimeFormat string
+
+	files map[string]*csvFile
+}
+
+// csvFile The file being written for a device.
+type csvFile struct {
+	prefix   string
+	codes    []int
+	file     *os.File
+	writer   *csv.Writer
+	filename string
+	day      string
+	size     int64
+}
+
+// Open Check the directory exists, creating it if required.
+func (cs *CSVSink) Open() error {
+	if cs.Directory == "" {
+		cs.Directory = "."
+	}
+	if cs.TimeFormat == "" {
+		cs.TimeFormat = time.RFC3339
+	}
+	return os.MkdirAll(cs.Directory, 0755)
+}
+
+// Write Append the readings to the current file for the device, starting a new file if required.
+func (cs *CSVSink) Write(readings Readings) error {
+	if cs.Device != "" && readings.Device != cs.Device {
+		return nil
+	}
+	cf, err := cs.deviceFile(readings)
+	if err != nil {
+		return err
+	}
+
+	tm := readings.Time
+	if tm.IsZero() {
+		tm = time.Now()
+	}
+	if err := cs.rotate(cf, readings, tm); err != nil {
+		return err
+	}
+
+	row := []string{tm.Format(cs.TimeFormat)}
+	for _, code := range cf.codes {
+		row = append(row, csvValue(readings, code))
+	}
+	return cf.writeRow(row)
+}
+
+// Close Close the current files.
+func (cs *CSVSink) Close() error {
+	var err error
+	for _, cf := range cs.files {
+		if cErr := cf.close(); err == nil {
+			err = cErr
+		}
+	}
+	return err
+}
+
+// Files Return the CSV files, including compressed files, in the directory for the devices
+// written.
+func (cs *CSVSink) Files() ([]string, error) {
+	prefixes := []string{cs.Prefix}
+	if len(cs.files) > 0 {
+		prefixes = nil
+		for _, cf := range cs.files {
+			prefixes = append(prefixes, cf.prefix)
+		}
+	}
+	var files []string
+	for _, prefix := range prefixes {
+		matches, err := filepath.Glob(filepath.Join(cs.Directory, prefix+"-*.csv*"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// deviceFile Return the details of the file for the device of the readings, selecting the codes
+// to write the first time the device is seen.
+func (cs *CSVSink) deviceFile(readings Readings) (*csvFile, error) {
+	if cf, ck := cs.files[readings.Device]; ck {
+		return cf, nil
+	}
+	if cs.Prefix != "" && cs.Device == "" && len(cs.files) > 0 {
+		return nil, fmt.Errorf("CSV sink with Prefix %s cannot write readings for more than one device, set Device to use a sink for each device",
+			cs.Prefix)
+	}
+	codes, err := readings.selectCodes(cs.Registers)
+	if err != nil {
+		return nil, err
+	}
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("No register values to write")
+	}
+	cf := &csvFile{prefix: cs.Prefix, codes: codes}
+	if cf.prefix == "" {
+		cf.prefix = readings.Device
+	}
+	if cs.files == nil {
+		cs.files = make(map[string]*csvFile)
+	}
+	cs.files[readings.Device] = cf
+	return cf, nil
+}
+
+// rotate Make sure the correct file is open for the time, starting a new file if the day has
+// changed or the current file is too big. An existing file for the day is only appended to if it
+// has the same header, otherwise it is moved out of the way.
+func (cs *CSVSink) rotate(cf *csvFile, readings Readings, tm time.Time) error {
+	day := tm.Format("2006-01-02")
+	if cf.file != nil && day == cf.day && (cs.MaxSize <= 0 || cf.size < cs.MaxSize) {
+		return nil
+	}
+
+	if cf.file != nil {
+		filename := cf.filename
+		if err := cf.close(); err != nil {
+			return err
+		}
+		if day == cf.day {
+			// The file is too big, so move it out of the way.
+			rotated, err := cs.moveAside(cf, filename, day, tm)
+			if err != nil {
+				return err
+			}
+			filename = rotated
+		}
+		cs.compress(filename)
+	}
+
+	cf.day = day
+	cf.filename = filepath.Join(cs.Directory, fmt.Sprintf("%s-%s.csv", cf.prefix, day))
+	header := csvHeader(readings, cf.codes)
+	existing, err := csvFileHeader(cf.filename)
+	if err != nil {
+		return err
+	}
+	if existing != nil && !sameRow(existing, header) {
+		// The registers have changed, so start a new file rather than mixing columns.
+		rotated, err := cs.moveAside(cf, cf.filename, day, tm)
+		if err != nil {
+			return err
+		}
+		cs.compress(rotated)
+	}
+
+	file, err := os.OpenFile(cf.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	cf.file = file
+	cf.writer = csv.NewWriter(file)
+	cf.size = info.Size()
+	if cf.size > 0 {
+		return nil
+	}
+	return cf.writeRow(header)
+}
+
+// moveAside Rename the file for the day by adding the time, returning the new filename.
+func (cs *CSVSink) moveAside(cf *csvFile, filename, day string, tm time.Time) (string, error) {
+	rotated := filepath.Join(cs.Directory, fmt.Sprintf("%s-%s-%s.csv", cf.prefix, day, tm.Format("150405.000")))
+	return rotated, os.Rename(filename, rotated)
+}
+
+// compress Compress the file if required, logging any problem.
+func (cs *CSVSink) compress(filename string) {
+	if !cs.Compress {
+		return
+	}
+	if err := compressFile(filename); err != nil {
+		log.Printf("Unable to compress %s: %s", filename, err)
+	}
+}
+
+// csvFileHeader Return the header row of an existing file, or nil if the file doesn't exist or
+// is empty.
+func csvFileHeader(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	rdr := csv.NewReader(file)
+	rdr.FieldsPerRecord = -1
+	header, err := rdr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	return header, err
+}
+
+func sameRow(aaa, bbb []string) bool {
+	if len(aaa) != len(bbb) {
+		return false
+	}
+	for i := range aaa {
+		if aaa[i] != bbb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (cf *csvFile) writeRow(row []string) error {
+	if err := cf.writer.Write(row); err != nil {
+		return err
+	}
+	cf.writer.Flush()
+	if err := cf.writer.Error(); err != nil {
+		return err
+	}
+	info, err := cf.file.Stat()
+	if err != nil {
+		return err
+	}
+	cf.size = info.Size()
+	return nil
+}
+
+func (cf *csvFile) close() error {
+	if cf.file == nil {
+		return nil
+	}
+	err := cf.file.Close()
+	cf.file = nil
+	return err
+}
+
+// csvHeader Return the header row, with the description and units of each register.
+func csvHeader(readings Readings, codes []int) []string {
+	header := []string{"Time"}
+	for _, code := range codes {
+		title := readings.Description(code)
+		if title == "" {
+			title = strconv.Itoa(code)
+		}
+		if units := readings.Units(code); units != "" {
+			title += " (" + units + ")"
+		}
+		header = append(header, title)
+	}
+	return header
+}
+
+func csvValue(readings Readings, code int) string {
+	if _, ck := readings.Values[code]; !ck {
+		return ""
+	}
+	return formatValue(readings.Value(code).Value, -1)
+}
+
+// compressFile Compress the file using gzip, replacing it with a file with .gz added to the name.
+func compressFile(filename string) error {
+	in, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(filename+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	gz.Name = filepath.Base(filename)
+	if _, err = io.Copy(gz, in); err == nil {
+		err = gz.Close()
+	}
+	if cErr := out.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(filename + ".gz")
+		return err
+	}
+	in.Close()
+	return os.Remove(filename)
+}
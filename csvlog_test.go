@@ -0,0 +1,217 @@
+package modbusdev
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSVSink(t *testing.T) {
+	dir := t.TempDir()
+	cs := &CSVSink{Directory: dir, Registers: []string{"pv1_power", "inverter_power"}, Compress: true}
+	if err := cs.Open(); err != nil {
+		t.Fatal(err)
+	}
+	readings := influxReadings(t)
+	readings.Time = time.Date(2021, 3, 1, 23, 59, 0, 0, time.Local)
+	for _, tm := range []time.Time{readings.Time, readings.Time.Add(30 * time.Second), readings.Time.Add(2 * time.Minute)} {
+		readings.Time = tm
+		if err := cs.Write(readings); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := cs.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	expected := []string{filepath.Join(dir, "solaxx1hybrid-2021-03-01.csv.gz"), filepath.Join(dir, "solaxx1hybrid-2021-03-02.csv")}
+	if strings.Join(files, " ") != strings.Join(expected, " ") {
+		t.Fatalf("Incorrect files. Got %v expected %v", files, expected)
+	}
+
+	file, err := os.Open(expected[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(gz).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || strings.Join(rows[0], ",") != "Time,PV1 Power (W),Inverter Power (W)" {
+		t.Fatalf("Incorrect rows %v", rows)
+	}
+	if rows[1][1] != "1234" || rows[1][2] != "2405" || rows[1][0] != "2021-03-01T23:59:00"+readings.Time.Format("Z07:00") {
+		t.Fatalf("Incorrect row %v", rows[1])
+	}
+
+	data, err := os.ReadFile(expected[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 {
+		t.Fatalf("Incorrect file contents %s", data)
+	}
+}
+
+func TestCSVSinkDevices(t *testing.T) {
+	meter, err := NewReader(NewFakeClient(), "sdm230")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meterReadings, err := meter.Readings(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings := influxReadings(t)
+	meterReadings.Time = readings.Time
+
+	dir := t.TempDir()
+	cs := &CSVSink{Directory: dir}
+	if err = cs.Open(); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []Readings{readings, meterReadings, readings} {
+		if err = cs.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = cs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := cs.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Incorrect number of files. Got %d expected 2", len(files))
+	}
+	day := readings.Time.Format("2006-01-02")
+	for _, r := range []Readings{readings, meterReadings} {
+		file, err := os.Open(filepath.Join(dir, r.Device+"-"+day+".csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := csv.NewReader(file).ReadAll()
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		header := csvHeader(r, cs.files[r.Device].codes)
+		if !sameRow(rows[0], header) {
+			t.Fatalf("Incorrect header for %s. Got %v expected %v", r.Device, rows[0], header)
+		}
+		for _, row := range rows[1:] {
+			if len(row) != len(header) {
+				t.Fatalf("Incorrect row for %s. Got %d columns expected %d", r.Device, len(row), len(header))
+			}
+		}
+	}
+
+	prefixed := &CSVSink{Directory: dir, Prefix: "solar"}
+	if err = prefixed.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err = prefixed.Write(readings); err != nil {
+		t.Fatal(err)
+	}
+	defer prefixed.Close()
+	if err = prefixed.Write(meterReadings); err == nil {
+		t.Fatalf("Expected an error writing a second device with a Prefix")
+	}
+
+	filtered := &CSVSink{Directory: dir, Prefix: "meter", Device: "sdm230"}
+	if err = filtered.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer filtered.Close()
+	for _, r := range []Readings{readings, meterReadings} {
+		if err = filtered.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(filtered.files) != 1 || filtered.files["sdm230"] == nil {
+		t.Fatalf("Incorrect devices written. Got %v expected sdm230", filtered.files)
+	}
+}
+
+func TestCSVSinkSize(t *testing.T) {
+	dir := t.TempDir()
+	cs := &CSVSink{Directory: dir, Prefix: "meter", MaxSize: 100}
+	if err := cs.Open(); err != nil {
+		t.Fatal(err)
+	}
+	readings := influxReadings(t)
+	for i := 0; i < 3; i++ {
+		readings.Time = readings.Time.Add(time.Second)
+		if err := cs.Write(readings); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cs.Close()
+	files, _ := cs.Files()
+	if len(files) != 3 {
+		t.Fatalf("Incorrect value. Got %d files expected 3", len(files))
+	}
+	for _, filename := range files {
+		file, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, err := csv.NewReader(file).Read()
+		file.Close()
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if header[0] != "Time" {
+			t.Fatalf("File %s has no header", filename)
+		}
+	}
+}
+
+func TestCSVSinkHeaderChanged(t *testing.T) {
+	dir := t.TempDir()
+	readings := influxReadings(t)
+	readings.Time = time.Date(2021, 3, 1, 12, 0, 0, 0, time.Local)
+	for _, registers := range [][]string{{"pv1_power"}, {"pv1_power"}, {"pv1_power", "inverter_power"}} {
+		cs := &CSVSink{Directory: dir, Registers: registers}
+		if err := cs.Open(); err != nil {
+			t.Fatal(err)
+		}
+		readings.Time = readings.Time.Add(time.Minute)
+		if err := cs.Write(readings); err != nil {
+			t.Fatal(err)
+		}
+		if err := cs.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.csv"))
+	if len(files) != 2 {
+		t.Fatalf("Incorrect value. Got %d files expected 2", len(files))
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "solaxx1hybrid-2021-03-01.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || lines[0] != "Time,PV1 Power (W),Inverter Power (W)" {
+		t.Fatalf("Incorrect file contents %s", data)
+	}
+}
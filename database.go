@@ -1,12 +1,22 @@
 package modbusdev
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	// Import postgresql access
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // DatabaseConnection Structure that holds details of database connection and query to be executed
@@ -17,75 +27,541 @@ type DatabaseConnection struct {
 	Password string
 	Name     string
 
+	// DSN A connection string (key=value pairs or a postgres:// URL). Any other connection
+	// details that are set are added to it.
+	DSN string
+
+	// SSL Deprecated, use SSLMode. When SSLMode is not set, true selects "require".
 	SSL bool
+	// SSLMode One of disable, allow, prefer, require, verify-ca or verify-full
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+
+	// ConnectTimeout Maximum time to wait when connecting, in seconds
+	ConnectTimeout  int
+	ApplicationName string
+	// PasswordEnv & PasswordFile allow the password to be read from an environment variable or
+	// file rather than being included in the configuration.
+	PasswordEnv  string
+	PasswordFile string
 
 	Query  string
 	Fields []DatabaseField
+	// Device Required when fields are identified by Register name rather than Code
+	Device string
+
+	// Table When CreateTable is set, the table is created if required and columns are added for
+	// any fields not already present. If no Fields are given, a column is added for every
+	// register of the Device. The Query is generated if not supplied.
+	Table       string
+	CreateTable bool
+
+	// Mode Either "wide" (the default) where each reading is a row with a column per field, or
+	// "narrow" where each register is stored as a row in Table, identified by device and code.
+	// In narrow mode all registers are stored unless Fields are given.
+	Mode string
+
+	// BatchSize When greater than 1, rows are held and inserted into the Table together once
+	// BatchSize are waiting or FlushInterval seconds have passed. In wide mode the Table must
+	// have a time column and a column for each field, and the time of the readings is stored.
+	// FlushInterval cannot be used without a BatchSize.
+	BatchSize     int
+	FlushInterval float64
 
+	// Connection pool settings. ConnMaxLifetime is in seconds. Zero values leave the defaults.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime float64
+
+	mu        sync.Mutex
 	db        *sql.DB
 	statement *sql.Stmt
+	registers map[int]Register
+	batch     *rowBatch
+	// timed Set when the generated Query takes the time of the readings as the first parameter.
+	timed bool
 }
 
 // DatabaseField Struct to allow for managing a relationship between a named field in the database
-// and the corresponding element in the device Map() data
+// and the corresponding element in the device Map() data. The element can be given either by
+// Code or by the Register name.
+//
+// By default the factored value is stored as a float (or a bool for coils, text for composites).
+// Raw selects the unfactored value and Type can be one of int, float, bool, string or enum. For
+// enums, Labels maps the value (as a string) to the label stored. Scale and Offset provide an
+// optional transform that is applied to numeric values before they are stored.
 type DatabaseField struct {
-	Name string
-	Code int
+	Name     string
+	Code     int
+	Register string
+
+	Raw    bool
+	Type   string
+	Labels map[string]string
+	Scale  float64
+	Offset float64
+}
+
+// value Return the value that should be stored for the field. If the register is not known the
+// populated member of the Value is used, see unknownValue. When no type is given for a known
+// register the value matches the column type created for it by fieldSQLType.
+func (fld DatabaseField) value(reg Register, known bool, val Value) (interface{}, error) {
+	fldType := strings.ToLower(fld.Type)
+	if fldType == "" && known {
+		switch fieldSQLType(fld, reg) {
+		case "INTEGER", "BIGINT":
+			fldType = "int"
+		case "BOOLEAN":
+			fldType = "bool"
+		default:
+			fldType = "float"
+		}
+	}
+	if !known && val.Text != "" {
+		if fldType != "" && fldType != "string" {
+			return nil, fmt.Errorf("Field %s is text and cannot be stored as %s", fld.Name, fld.Type)
+		}
+		return val.Text, nil
+	}
+
+	var n float64
+	switch {
+	case !known:
+		n = unknownValue(val)
+	case fld.Raw:
+		n = reg.rawValue(val)
+	case reg.Format == "ieee32":
+		n = val.Ieee32
+	default:
+		reg.applyFactor(&val)
+		n = val.Ieee32
+	}
+	if fld.Scale != 0 {
+		n *= fld.Scale
+	}
+	n += fld.Offset
+
+	switch fldType {
+	case "", "float":
+		return n, nil
+	case "int":
+		return int64(math.Round(n)), nil
+	case "bool":
+		if known && reg.Format == "coil" {
+			return val.Coil, nil
+		}
+		return n != 0, nil
+	case "string":
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+	case "enum":
+		key := strconv.FormatInt(int64(math.Round(n)), 10)
+		if label, ck := fld.Labels[key]; ck {
+			return label, nil
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("Field %s has unknown type '%s'", fld.Name, fld.Type)
+}
+
+// unknownValue Return the numeric value when the register format is not known. The Ieee32 member
+// is used if set, as it holds the factored value, otherwise whichever member has been set.
+func unknownValue(val Value) float64 {
+	switch {
+	case val.Ieee32 != 0:
+		return val.Ieee32
+	case val.Signed32 != 0:
+		return float64(val.Signed32)
+	case val.Unsigned32 != 0:
+		return float64(val.Unsigned32)
+	case val.Signed16 != 0:
+		return float64(val.Signed16)
+	case val.Unsigned16 != 0:
+		return float64(val.Unsigned16)
+	case val.Coil:
+		return 1
+	}
+	return 0
 }
 
 // OpenDatabase Open a database connection and call Ping to verify the connection.
 func (dbC *DatabaseConnection) OpenDatabase() error {
-	// Establish connection to postgresql....
-	db, err := sql.Open("postgres", dbC.getConnectionString())
+	if dbC.FlushInterval > 0 && dbC.BatchSize <= 1 {
+		return fmt.Errorf("FlushInterval can only be used with a BatchSize greater than 1")
+	}
+	if err := resolveFields(dbC.Device, dbC.Fields); err != nil {
+		return err
+	}
+	regs, err := deviceRegisters(dbC.Device)
 	if err != nil {
 		return err
 	}
-	dbC.db = db
-	err = dbC.db.Ping()
+	dbC.registers = regs
+	if err = dbC.connect(context.Background()); err != nil {
+		return err
+	}
+	if dbC.narrow() {
+		if dbC.Table == "" {
+			dbC.db.Close()
+			return fmt.Errorf("A Table must be specified for narrow mode")
+		}
+		if dbC.CreateTable {
+			if _, err = dbC.db.Exec(narrowTableSQL(dbC.Table)); err != nil {
+				dbC.db.Close()
+				return err
+			}
+		}
+	} else if dbC.CreateTable {
+		if err = dbC.migrateTable(); err != nil {
+			log.Printf("Unable to create table %s: %s", dbC.Table, err)
+			dbC.db.Close()
+			return err
+		}
+	}
+	if dbC.BatchSize > 1 {
+		if dbC.Table == "" {
+			dbC.db.Close()
+			return fmt.Errorf("A Table must be specified to insert in batches")
+		}
+		columns := narrowColumns
+		if !dbC.narrow() {
+			columns = []string{"time"}
+			for _, fld := range dbC.Fields {
+				columns = append(columns, fld.Name)
+			}
+		}
+		interval := time.Duration(dbC.FlushInterval * float64(time.Second))
+		dbC.batch = newRowBatch(dbC.Table, columns, dbC.BatchSize, interval, func(qry string, args ...interface{}) error {
+			return dbC.exec(context.Background(), qry, args...)
+		})
+	}
+	return nil
+}
+
+// postgresDriver The database/sql driver used to connect, replaced when testing.
+var postgresDriver = "postgres"
+
+// connect Establish the connection to postgresql, apply the pool settings and call Ping to
+// verify the connection.
+func (dbC *DatabaseConnection) connect(ctx context.Context) error {
+	connStr, err := dbC.getConnectionString()
 	if err != nil {
-		dbC.db.Close()
 		return err
 	}
+	db, err := sql.Open(postgresDriver, connStr)
+	if err != nil {
+		return err
+	}
+	if dbC.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(dbC.MaxOpenConns)
+	}
+	if dbC.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(dbC.MaxIdleConns)
+	}
+	if dbC.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(time.Duration(dbC.ConnMaxLifetime * float64(time.Second)))
+	}
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return err
+	}
+	dbC.db = db
 	return nil
 }
 
-func (dbC DatabaseConnection) getConnectionString() string {
-	connDetails := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s",
-		dbC.Host, dbC.Port, dbC.User, dbC.Password, dbC.Name)
-	if dbC.SSL {
-		connDetails += " sslmode=enable"
-	} else {
-		connDetails += " sslmode=disable"
+// reconnect Close the existing connection and statement and open a new connection. The
+// statement will be prepared again when next required. If the connection cannot be opened db is
+// left as nil so that it is tried again when next used.
+func (dbC *DatabaseConnection) reconnect(ctx context.Context) error {
+	if dbC.statement != nil {
+		dbC.statement.Close()
+		dbC.statement = nil
+	}
+	if dbC.db != nil {
+		dbC.db.Close()
+		dbC.db = nil
+	}
+	return dbC.connect(ctx)
+}
+
+// run Run the function while holding the lock, connecting first if a previous attempt to
+// reconnect failed. If the function fails because the connection to the database has been lost,
+// reconnect and try once more.
+func (dbC *DatabaseConnection) run(ctx context.Context, fn func() error) error {
+	dbC.mu.Lock()
+	defer dbC.mu.Unlock()
+	if dbC.db == nil {
+		if err := dbC.connect(ctx); err != nil {
+			return err
+		}
 	}
-	return connDetails
+	err := fn()
+	if err == nil || !isConnectionError(err) {
+		return err
+	}
+	log.Printf("Database connection lost, reconnecting: %s", err)
+	if err = dbC.reconnect(ctx); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// exec Execute the query with the arguments, reconnecting if required.
+func (dbC *DatabaseConnection) exec(ctx context.Context, qry string, args ...interface{}) error {
+	return dbC.run(ctx, func() error {
+		_, err := dbC.db.ExecContext(ctx, qry, args...)
+		return err
+	})
+}
+
+// isConnectionError Return true if the error indicates the connection to the database has been
+// lost, rather than a problem with the query.
+func isConnectionError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Connection exceptions, or the server shutting down or not yet accepting connections
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03":
+			return true
+		}
+		return pqErr.Code.Class() == "08"
+	}
+	return false
+}
+
+func (dbC *DatabaseConnection) narrow() bool {
+	return strings.ToLower(dbC.Mode) == "narrow"
 }
 
-// Execute Execute the stored query using supplied map of values
-func (dbC DatabaseConnection) Execute(data map[int]Value) error {
-	if dbC.statement == nil {
-		names := ""
-		placeholders := ""
-		for i, fld := range dbC.Fields {
-			names += ", " + fld.Name
-			placeholders += fmt.Sprintf(",$%d", i+1)
+// resolveFields Set the Code for any fields that were configured using a Register name and check
+// the types requested are valid. The register formats are needed to select the value stored for
+// fields using Raw, Type, Scale or Offset, so the device must be given for those.
+func resolveFields(device string, fields []DatabaseField) error {
+	for i, fld := range fields {
+		switch strings.ToLower(fld.Type) {
+		case "", "int", "float", "bool", "string", "enum":
+		default:
+			return fmt.Errorf("Field %s has unknown type '%s'", fld.Name, fld.Type)
+		}
+		if device == "" && (fld.Raw || fld.Type != "" || fld.Scale != 0 || fld.Offset != 0) {
+			return fmt.Errorf("Device must be specified to use Raw, Type, Scale or Offset for field %s", fld.Name)
+		}
+		if fld.Register == "" {
+			continue
+		}
+		regs, err := RegistersByName(device)
+		if err != nil {
+			return fmt.Errorf("Unable to find register '%s' for field %s: %s", fld.Register, fld.Name, err)
 		}
-		qry := fmt.Sprintf(dbC.Query, names, placeholders)
-		stmt, err := dbC.db.Prepare(qry)
+		code, err := codeByName(registerNames(regs, namesByName(device)), compositesByName(device), fld.Register)
 		if err != nil {
-			log.Printf("Error creating insertion statement")
 			return err
 		}
-		dbC.statement = stmt
+		fields[i].Code = code
 	}
-	qryData := make([]interface{}, len(dbC.Fields))
-	for i, fld := range dbC.Fields {
+	return nil
+}
+
+// insertQuery Complete the query template by adding the field names and placeholders. The
+// placeholder function is given the 1 based index of the field.
+func insertQuery(query string, fields []DatabaseField, placeholder func(int) string) string {
+	names := ""
+	placeholders := ""
+	for i, fld := range fields {
+		names += ", " + fld.Name
+		placeholders += "," + placeholder(i+1)
+	}
+	return fmt.Sprintf(query, names, placeholders)
+}
+
+// fieldData Return the values from the map data for each field, in the order of the fields. The
+// registers are used to select the appropriate Value member, so may be nil if not known.
+func fieldData(fields []DatabaseField, registers map[int]Register, data map[int]Value) ([]interface{}, error) {
+	qryData := make([]interface{}, len(fields))
+	for i, fld := range fields {
 		val, ck := data[fld.Code]
 		if !ck {
-			return fmt.Errorf("Code %d [%s] not listed in supplied map data", fld.Code, fld.Name)
+			return nil, fmt.Errorf("Code %d [%s] not listed in supplied map data", fld.Code, fld.Name)
+		}
+		reg, known := registers[fld.Code]
+		fv, err := fld.value(reg, known, val)
+		if err != nil {
+			return nil, err
+		}
+		qryData[i] = fv
+	}
+	return qryData, nil
+}
+
+// deviceRegisters Return the registers for the device, or nil if no device is given.
+func deviceRegisters(device string) (map[int]Register, error) {
+	if device == "" {
+		return nil, nil
+	}
+	return RegistersByName(device)
+}
+
+// getConnectionString Return the connection string, after checking the options are valid.
+func (dbC *DatabaseConnection) getConnectionString() (string, error) {
+	var opts []string
+	if dbC.DSN != "" {
+		dsn := dbC.DSN
+		if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+			var err error
+			if dsn, err = pq.ParseURL(dsn); err != nil {
+				return "", fmt.Errorf("Invalid database URL: %s", err)
+			}
+		}
+		opts = append(opts, dsn)
+	}
+	addOpt := func(key, value string) {
+		if value != "" {
+			opts = append(opts, key+"="+quoteConnValue(value))
+		}
+	}
+	addOpt("host", dbC.Host)
+	if dbC.Port > 0 {
+		addOpt("port", strconv.Itoa(int(dbC.Port)))
+	}
+	addOpt("user", dbC.User)
+	password, err := dbC.password()
+	if err != nil {
+		return "", err
+	}
+	addOpt("password", password)
+	addOpt("dbname", dbC.Name)
+
+	sslMode := strings.ToLower(dbC.SSLMode)
+	switch sslMode {
+	case "":
+		if dbC.SSL {
+			sslMode = "require"
+		} else if dbC.DSN == "" {
+			sslMode = "disable"
+		}
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		return "", fmt.Errorf("Invalid SSLMode '%s', must be one of disable, allow, prefer, require, verify-ca or verify-full", dbC.SSLMode)
+	}
+	addOpt("sslmode", sslMode)
+	certs := []struct{ key, filename string }{
+		{"sslrootcert", dbC.SSLRootCert},
+		{"sslcert", dbC.SSLCert},
+		{"sslkey", dbC.SSLKey},
+	}
+	for _, cert := range certs {
+		if cert.filename == "" {
+			continue
+		}
+		if _, err := os.Stat(cert.filename); err != nil {
+			return "", fmt.Errorf("Unable to use %s: %s", cert.key, err)
+		}
+		addOpt(cert.key, cert.filename)
+	}
+	if (dbC.SSLCert == "") != (dbC.SSLKey == "") {
+		return "", fmt.Errorf("Both SSLCert and SSLKey must be given to use a client certificate")
+	}
+
+	if dbC.ConnectTimeout < 0 {
+		return "", fmt.Errorf("Invalid ConnectTimeout of %d", dbC.ConnectTimeout)
+	} else if dbC.ConnectTimeout > 0 {
+		addOpt("connect_timeout", strconv.Itoa(dbC.ConnectTimeout))
+	}
+	addOpt("application_name", dbC.ApplicationName)
+	return strings.Join(opts, " "), nil
+}
+
+// password Return the password, which can be given directly or read from an environment variable
+// or file. Only one source may be used.
+func (dbC *DatabaseConnection) password() (string, error) {
+	sources := 0
+	for _, src := range []string{dbC.Password, dbC.PasswordEnv, dbC.PasswordFile} {
+		if src != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return "", fmt.Errorf("Only one of Password, PasswordEnv and PasswordFile may be given")
+	}
+	switch {
+	case dbC.PasswordEnv != "":
+		password, ck := os.LookupEnv(dbC.PasswordEnv)
+		if !ck {
+			return "", fmt.Errorf("Environment variable %s for the database password is not set", dbC.PasswordEnv)
 		}
-		qryData[i] = val.Ieee32
+		return password, nil
+	case dbC.PasswordFile != "":
+		data, err := os.ReadFile(dbC.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("Unable to read database password: %s", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return dbC.Password, nil
+}
+
+// quoteConnValue Quote a value for use in a key=value connection string, if required.
+func quoteConnValue(value string) string {
+	if !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+// Execute Execute the stored query using supplied map of values. Narrow mode requires details
+// of the registers, so Write must be used instead.
+func (dbC *DatabaseConnection) Execute(data map[int]Value) error {
+	return dbC.ExecuteContext(context.Background(), data)
+}
+
+// ExecuteContext Execute the stored query using supplied map of values and context.
+func (dbC *DatabaseConnection) ExecuteContext(ctx context.Context, data map[int]Value) error {
+	if dbC.narrow() {
+		return fmt.Errorf("Execute cannot be used in narrow mode, use Write")
+	}
+	return dbC.execute(ctx, dbC.registers, data, time.Now())
+}
+
+// execute Execute the stored query, using the registers to select the values stored. When
+// inserting in batches the values are added to the batch along with the time. The statement is
+// prepared on first use and kept until the connection is closed or lost.
+func (dbC *DatabaseConnection) execute(ctx context.Context, registers map[int]Register, data map[int]Value, tm time.Time) error {
+	qryData, err := fieldData(dbC.Fields, registers, data)
+	if err != nil {
+		return err
+	}
+	if dbC.batch != nil {
+		return dbC.batch.add(append([]interface{}{tm}, qryData...))
+	}
+	offset := 0
+	if dbC.timed {
+		offset = 1
+		qryData = append([]interface{}{tm}, qryData...)
 	}
-	_, err := dbC.statement.Exec(qryData...)
+	err = dbC.run(ctx, func() error {
+		if dbC.statement == nil {
+			qry := insertQuery(dbC.Query, dbC.Fields, func(n int) string { return fmt.Sprintf("$%d", n+offset) })
+			stmt, err := dbC.db.PrepareContext(ctx, qry)
+			if err != nil {
+				log.Printf("Error creating insertion statement")
+				return err
+			}
+			dbC.statement = stmt
+		}
+		_, err := dbC.statement.ExecContext(ctx, qryData...)
+		return err
+	})
 	if err != nil {
 		log.Printf("Error writing sensor data to database: %s", err)
 		return err
@@ -93,13 +569,54 @@ func (dbC DatabaseConnection) Execute(data map[int]Value) error {
 	return nil
 }
 
-// Close Close the database connection, closing any open statements as well.
-func (dbC DatabaseConnection) Close() {
-	if dbC.db == nil {
-		return
+// Flush Insert any rows waiting to be inserted as a batch.
+func (dbC *DatabaseConnection) Flush() error {
+	if dbC.batch == nil {
+		return nil
 	}
+	return dbC.batch.flush()
+}
+
+// Close Close the database connection, closing any open statements as well. Any rows waiting to
+// be inserted as a batch are inserted first.
+func (dbC *DatabaseConnection) Close() error {
+	if err := dbC.Flush(); err != nil {
+		log.Printf("Error inserting rows on close: %s", err)
+	}
+	dbC.mu.Lock()
+	defer dbC.mu.Unlock()
 	if dbC.statement != nil {
 		dbC.statement.Close()
+		dbC.statement = nil
+	}
+	if dbC.db == nil {
+		return nil
+	}
+	err := dbC.db.Close()
+	dbC.db = nil
+	return err
+}
+
+// Open Open the database connection. Provided so that a DatabaseConnection can be used as a Sink.
+func (dbC *DatabaseConnection) Open() error {
+	return dbC.OpenDatabase()
+}
+
+// Write Insert the readings into the database. Provided so that a DatabaseConnection can be used
+// as a Sink.
+func (dbC *DatabaseConnection) Write(readings Readings) error {
+	return dbC.WriteContext(context.Background(), readings)
+}
+
+// WriteContext Insert the readings into the database using the supplied context. The time of the
+// readings is stored unless a Query was supplied.
+func (dbC *DatabaseConnection) WriteContext(ctx context.Context, readings Readings) error {
+	if dbC.narrow() {
+		return dbC.writeNarrow(ctx, readings)
+	}
+	tm := readings.Time
+	if tm.IsZero() {
+		tm = time.Now()
 	}
-	dbC.db.Close()
+	return dbC.execute(ctx, readings.Registers, readings.Values, tm)
 }
@@ -0,0 +1,272 @@
+package modbusdev
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestDatabaseResolveFields(t *testing.T) {
+	dbC := DatabaseConnection{Device: "solaxx1hybrid", Fields: []DatabaseField{
+		{Name: "pv1", Register: "pv1_power"},
+		{Name: "inverter", Code: 30003},
+	}}
+	if err := resolveFields(dbC.Device, dbC.Fields); err != nil {
+		t.Fatal(err)
+	}
+	if dbC.Fields[0].Code != 30011 || dbC.Fields[1].Code != 30003 {
+		t.Fatalf("Incorrect codes for fields: %+v", dbC.Fields)
+	}
+}
+
+func TestFieldData(t *testing.T) {
+	regs, _ := RegistersByName("solaxx1hybrid")
+	data := map[int]Value{
+		30001:  {Unsigned16: 2401},
+		30010:  {Unsigned16: 2},
+		30022:  {Signed16: -25},
+		30011:  {Unsigned16: 1500},
+		940163: {Text: "00:12:34:AB:CD:EF"},
+	}
+	fields := []DatabaseField{
+		{Name: "voltage", Code: 30001},
+		{Name: "voltage_raw", Code: 30001, Raw: true, Type: "int"},
+		{Name: "mode", Code: 30010, Type: "enum", Labels: map[string]string{"2": "normal"}},
+		{Name: "battery", Code: 30022, Type: "float"},
+		{Name: "pv1_kw", Code: 30011, Scale: 0.001},
+		{Name: "generating", Code: 30011, Type: "bool"},
+		{Name: "mac", Code: 940163},
+	}
+	qryData, err := fieldData(fields, regs, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := qryData[0].(float64); v < 240.09 || v > 240.11 {
+		t.Fatalf("Incorrect factored value %v", qryData[0])
+	}
+	if qryData[1] != int64(2401) || qryData[2] != "normal" || qryData[4] != 1.5 || qryData[5] != true || qryData[6] != "00:12:34:AB:CD:EF" {
+		t.Fatalf("Incorrect values returned: %v", qryData)
+	}
+	if v := qryData[3].(float64); v > -2.49 || v < -2.51 {
+		t.Fatalf("Incorrect signed value %v", qryData[3])
+	}
+
+	// Without the registers, the Ieee32 member is used as before
+	qryData, err = fieldData(fields[:1], nil, map[int]Value{30001: {Unsigned16: 2401, Ieee32: 240.1}})
+	if err != nil || qryData[0] != 240.1 {
+		t.Fatalf("Incorrect value without registers: %v [%v]", qryData, err)
+	}
+	// Unfactored values without the registers use the member that has been set
+	for _, val := range []Value{{Unsigned16: 2401}, {Signed16: 2401}, {Unsigned32: 2401}, {Signed32: 2401}} {
+		qryData, err = fieldData(fields[:1], nil, map[int]Value{30001: val})
+		if err != nil || qryData[0] != 2401.0 {
+			t.Fatalf("Incorrect value without registers for %+v. Got %v expected 2401 [%v]", val, qryData, err)
+		}
+	}
+	if err := resolveFields("", []DatabaseField{{Name: "bad", Code: 30001, Type: "date"}}); err == nil {
+		t.Fatal("Expected error for unknown field type")
+	}
+	err = resolveFields("", []DatabaseField{{Name: "voltage_raw", Code: 30001, Raw: true}})
+	if err == nil || err.Error() != "Device must be specified to use Raw, Type, Scale or Offset for field voltage_raw" {
+		t.Fatalf("Incorrect error for Raw without a device. Got %v", err)
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{driver.ErrBadConn, true},
+		{fmt.Errorf("write failed: %w", io.EOF), true},
+		{&net.OpError{Op: "read", Err: fmt.Errorf("connection reset")}, true},
+		{&pq.Error{Code: "08006"}, true},
+		{&pq.Error{Code: "57P01"}, true},
+		{&pq.Error{Code: "57014"}, false},
+		{&pq.Error{Code: "23505"}, false},
+		{fmt.Errorf("syntax error"), false},
+	}
+	for _, tst := range tests {
+		if isConnectionError(tst.err) != tst.expected {
+			t.Fatalf("Incorrect result for %v, expected %t", tst.err, tst.expected)
+		}
+	}
+}
+
+func TestConnectionString(t *testing.T) {
+	dbC := DatabaseConnection{Host: "localhost", Port: 5432, User: "user", Password: "pass word", Name: "modbus"}
+	connStr, err := dbC.getConnectionString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connStr != "host=localhost port=5432 user=user password='pass word' dbname=modbus sslmode=disable" {
+		t.Fatalf("Incorrect connection string: %s", connStr)
+	}
+
+	dbC.SSL = true
+	if connStr, _ = dbC.getConnectionString(); !strings.HasSuffix(connStr, "sslmode=require") {
+		t.Fatalf("SSL should select sslmode=require: %s", connStr)
+	}
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	pwFile := filepath.Join(dir, "password")
+	if err = os.WriteFile(caFile, []byte("cert"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(pwFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dbC = DatabaseConnection{DSN: "postgres://user@db.example.com:5433/modbus", SSLMode: "verify-full",
+		SSLRootCert: caFile, PasswordFile: pwFile, ConnectTimeout: 10, ApplicationName: "modbusdev"}
+	connStr, err = dbC.getConnectionString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opt := range []string{"host='db.example.com'", "port='5433'", "password=secret", "sslmode=verify-full",
+		"sslrootcert=" + caFile, "connect_timeout=10", "application_name=modbusdev"} {
+		if !strings.Contains(connStr, opt) {
+			t.Fatalf("Connection string missing %s: %s", opt, connStr)
+		}
+	}
+
+	t.Setenv("MODBUSDEV_TEST_PASSWORD", "fromenv")
+	dbC = DatabaseConnection{Host: "localhost", PasswordEnv: "MODBUSDEV_TEST_PASSWORD"}
+	if connStr, _ = dbC.getConnectionString(); !strings.Contains(connStr, "password=fromenv") {
+		t.Fatalf("Password not read from environment: %s", connStr)
+	}
+
+	invalid := []*DatabaseConnection{
+		{SSLMode: "enable"},
+		{SSLRootCert: filepath.Join(dir, "missing.crt")},
+		{SSLCert: caFile},
+		{Password: "pass", PasswordEnv: "MODBUSDEV_TEST_PASSWORD"},
+		{PasswordEnv: "MODBUSDEV_TEST_MISSING"},
+		{DSN: "postgres://[bad"},
+	}
+	for i, dbC := range invalid {
+		if _, err := dbC.getConnectionString(); err == nil {
+			t.Fatalf("Expected error for invalid configuration %d", i)
+		}
+	}
+}
+
+// fakeDriver A database/sql driver where connections can be refused and existing connections
+// lost, to test reconnecting.
+type fakeDriver struct {
+	mu   sync.Mutex
+	down bool
+	rows [][]driver.Value
+}
+
+var testDriver = &fakeDriver{}
+
+func init() {
+	sql.Register("modbusdev-test", testDriver)
+}
+
+func (fd *fakeDriver) setDown(down bool) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	fd.down = down
+}
+
+// inserted Return the values of the rows inserted.
+func (fd *fakeDriver) inserted() [][]driver.Value {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	return append([][]driver.Value{}, fd.rows...)
+}
+
+func (fd *fakeDriver) Open(name string) (driver.Conn, error) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	if fd.down {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}
+	}
+	return fakeConn{fd}, nil
+}
+
+type fakeConn struct {
+	fd *fakeDriver
+}
+
+func (fc fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{fc.fd, query}, nil }
+func (fc fakeConn) Close() error                              { return nil }
+func (fc fakeConn) Begin() (driver.Tx, error)                 { return nil, fmt.Errorf("Not supported") }
+
+type fakeStmt struct {
+	fd    *fakeDriver
+	query string
+}
+
+func (fs fakeStmt) Close() error  { return nil }
+func (fs fakeStmt) NumInput() int { return -1 }
+
+func (fs fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	fs.fd.mu.Lock()
+	defer fs.fd.mu.Unlock()
+	if fs.fd.down {
+		return nil, driver.ErrBadConn
+	}
+	if strings.HasPrefix(fs.query, "INSERT") {
+		fs.fd.rows = append(fs.fd.rows, args)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (fs fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return fakeRows{}, nil
+}
+
+// fakeRows An empty result, so that no columns are found when migrating a table.
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string              { return []string{"column_name"} }
+func (fakeRows) Close() error                   { return nil }
+func (fakeRows) Next(dest []driver.Value) error { return io.EOF }
+
+func TestDatabaseReconnect(t *testing.T) {
+	defer func(orig string) { postgresDriver = orig }(postgresDriver)
+	postgresDriver = "modbusdev-test"
+	testDriver.setDown(false)
+	start := len(testDriver.inserted())
+
+	dbC := DatabaseConnection{Query: "INSERT INTO solax (time%s) VALUES (NOW()%s)",
+		Fields: []DatabaseField{{Name: "pv1", Code: 30011}}}
+	if err := dbC.OpenDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	defer dbC.Close()
+	data := map[int]Value{30011: {Ieee32: 1500}}
+	if err := dbC.Execute(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// The connection is lost and cannot be opened again
+	testDriver.setDown(true)
+	if err := dbC.Execute(data); err == nil {
+		t.Fatal("Expected error while the database is down")
+	}
+	if dbC.db != nil {
+		t.Fatal("Closed database was kept after reconnecting failed")
+	}
+
+	// Once the database is back the next Execute connects again
+	testDriver.setDown(false)
+	if err := dbC.Execute(data); err != nil {
+		t.Fatalf("Unexpected error after the database recovered: %s", err)
+	}
+	if n := len(testDriver.inserted()) - start; n != 2 {
+		t.Fatalf("Incorrect number of inserts. Got %d expected 2", n)
+	}
+}
@@ -2,6 +2,7 @@ package modbusdev
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -30,6 +31,31 @@ var sdm230 = map[int]Register{
 	30345: {"Total Reactive Energy", "kVArh", 0x0158, "ieee32", 1},
 }
 
+var sdm230Names = map[int]string{
+	30001: "line_to_neutral_volts",
+	30007: "current",
+	30013: "active_power",
+	30019: "apparent_power",
+	30025: "reactive_power",
+	30031: "power_factor",
+	30037: "phase_angle",
+	30071: "frequency",
+	30073: "import_active_energy",
+	30075: "export_active_energy",
+	30077: "import_reactive_energy",
+	30079: "export_reactive_energy",
+	30085: "total_system_power_demand",
+	30087: "maximum_total_system_power_demand",
+	30089: "current_system_positive_power_demand",
+	30091: "maximum_system_positive_power_demand",
+	30093: "current_system_reverse_power_demand",
+	30095: "maximum_system_reverse_power_demand",
+	30259: "current_demand",
+	30265: "maximum_current_demand",
+	30343: "total_active_energy",
+	30345: "total_reactive_energy",
+}
+
 // Additional registers that may be of interest to some.
 var sdm230Ex = map[int]Register{
 	// Included as example in protocol document?
@@ -44,6 +70,17 @@ var sdm230Ex = map[int]Register{
 	463792: {"Pulse Indicators", "", 0xf92f, "u32", 1},
 }
 
+var sdm230ExNames = map[int]string{
+	40013:  "relay_pulse_width",
+	40019:  "network_parity_stop",
+	40021:  "network_node",
+	40029:  "network_baud_rate",
+	462721: "screen_settings",
+	463761: "system_power",
+	463776: "measurement_mode",
+	463792: "pulse_indicators",
+}
+
 /*
  * Solax register information from
  * https://github.com/wills106/homeassistant-config/blob/43365e6eed685e82763f786e7a46c387083a93b5/packages/solax.yaml
@@ -89,6 +126,44 @@ var solaxX1Hybrid = map[int]Register{
 	30082: {"Energy Total", "kW", 0x51, "u32", .001},
 }
 
+var solaxX1HybridNames = map[int]string{
+	30001: "grid_voltage",
+	30002: "grid_current",
+	30003: "inverter_power",
+	30004: "pv1_voltage",
+	30005: "pv2_voltage",
+	30006: "pv1_current",
+	30007: "pv2_current",
+	30008: "grid_frequency",
+	30009: "inner_temp",
+	30010: "run_mode",
+	30011: "pv1_power",
+	30012: "pv2_power",
+	30021: "battery_voltage",
+	30022: "battery_current",
+	30023: "battery_power",
+	30024: "charger_board_temperature",
+	30025: "battery_temperature",
+	30026: "charger_boost_temperature",
+	30029: "battery_capacity",
+	30030: "battery_energy_charged",
+	30032: "bms_warning",
+	30033: "battery_energy_discharged",
+	30036: "battery_state_of_health",
+	30065: "inverter_fault",
+	30067: "charger_fault",
+	30068: "manager_fault",
+	30071: "measured_power",
+	30073: "feed_in_energy",
+	30075: "consumed_energy",
+	30077: "eps_voltage",
+	30078: "eps_current",
+	30079: "eps_va",
+	30080: "eps_frequency",
+	30081: "energy_today",
+	30082: "energy_total",
+}
+
 // Additional registers that may be of interest to some.
 var solaxX1HybridEx = map[int]Register{
 	// The following registers can be read to give the described values,
@@ -111,11 +186,11 @@ var solaxX1HybridEx = map[int]Register{
 	40138: {"Month", "", 0x89, "u16", 1},
 	40139: {"Year", "", 0x8A, "u16", 1},
 
-	// Write as register 34
+	// Write as register 34 (0x22)
 	40140: {"Min Charger Capacity", "%", 0x8C, "u16", 1},
-	// Write as register 36
+	// Write as register 36 (0x24)
 	40145: {"Charge Max Current", "A", 0x90, "u16", .1},
-	// Write as register 37
+	// Write as register 37 (0x25)
 	40146: {"Discharge Max Current", "A", 0x91, "u16", .1},
 
 	// Times for Force Time Use
@@ -150,6 +225,79 @@ var solaxX1HybridEx = map[int]Register{
 	40267: {"Meter 2 ID", "", 0x10A, "u16", 1},
 }
 
+var solaxX1HybridExNames = map[int]string{
+	40026: "vac_lower",
+	40027: "vac_upper",
+	40028: "fec_lower",
+	40029: "fec_upper",
+	40032: "vac_10m_avg",
+	40033: "vac_lower_slow",
+	40034: "vac_upper_slow",
+	40035: "fec_lower_slow",
+	40036: "fec_upper_slow",
+	40135: "minutes",
+	40136: "hours",
+	40137: "day",
+	40138: "month",
+	40139: "year",
+	40140: "min_charger_capacity",
+	40145: "charge_max_current",
+	40146: "discharge_max_current",
+	40147: "charge_period_1_start_hour",
+	40148: "charge_period_1_start_minutes",
+	40149: "charge_period_1_finish_hour",
+	40150: "charge_period_1_finish_minutes",
+	40155: "charge_period_2_start_hour",
+	40156: "charge_period_2_start_minutes",
+	40157: "charge_period_2_finish_hour",
+	40158: "charge_period_2_finish_minutes",
+	40163: "mac_address_1",
+	40164: "mac_address_2",
+	40165: "mac_address_3",
+	40183: "max_export_power",
+	40187: "rated_power",
+	40223: "battery_version_number",
+	40225: "admin_password",
+	40255: "backup_start_hour",
+	40256: "backup_start_minute",
+	40257: "backup_finish_hour",
+	40258: "backup_finish_minute",
+	40265: "use_meter",
+	40266: "meter_1_id",
+	40267: "meter_2_id",
+}
+
+// Values that are made up from several of the registers above.
+var solaxX1HybridComposites = map[int]Composite{
+	940135: solaxX1HybridClock.composite("Date & Time", "date_time"),
+	940163: {Description: "MAC Address", Codes: []int{40163, 40164, 40165}, Format: "mac", Name: "mac_address"},
+}
+
+// The Solax settings are not written to the same register they are read from. This maps the
+// codes above to the register that should be used when writing.
+var solaxX1HybridWrite = map[int]uint16{
+	40140: 0x22,
+	40145: 0x24,
+	40146: 0x25,
+	40183: 0x42,
+
+	// Clock
+	40135: 0x01,
+	40136: 0x02,
+	40137: 0x03,
+	40138: 0x04,
+	40139: 0x05,
+}
+
+var solaxX1HybridClock = DateTimeRegisters{
+	Year:      40139,
+	Month:     40138,
+	Day:       40137,
+	Hour:      40136,
+	Minute:    40135,
+	ShortYear: true,
+}
+
 // Not sure if there is a better way to do this, but it works for now.
 func joinMaps(aaa, bbb map[int]Register) map[int]Register {
 	regMap := make(map[int]Register)
@@ -162,6 +310,11 @@ func joinMaps(aaa, bbb map[int]Register) map[int]Register {
 	return regMap
 }
 
+// Devices Return the names of the devices that are known, as accepted by RegistersByName.
+func Devices() []string {
+	return []string{"sdm230", "sdm230ex", "solaxx1hybrid", "solaxx1hybridex"}
+}
+
 // RegistersByName Given a device string, return the approrpriate map of registers.
 func RegistersByName(device string) (registers map[int]Register, err error) {
 	switch strings.ToLower(device) {
@@ -178,3 +331,87 @@ func RegistersByName(device string) (registers map[int]Register, err error) {
 	}
 	return
 }
+
+// RegisterNames Given a device string, return the names of the registers, e.g. pv1_power, keyed
+// by code.
+func RegisterNames(device string) (map[int]string, error) {
+	registers, err := RegistersByName(device)
+	if err != nil {
+		return nil, err
+	}
+	return registerNames(registers, namesByName(device)), nil
+}
+
+// namesByName Given a device string, return the names given to the device registers.
+func namesByName(device string) map[int]string {
+	switch strings.ToLower(device) {
+	case "sdm230":
+		return sdm230Names
+	case "sdm230ex":
+		return joinNames(sdm230Names, sdm230ExNames)
+	case "solaxx1hybrid":
+		return solaxX1HybridNames
+	case "solaxx1hybridex":
+		return joinNames(solaxX1HybridNames, solaxX1HybridExNames)
+	}
+	return nil
+}
+
+func joinNames(aaa, bbb map[int]string) map[int]string {
+	names := make(map[int]string, len(aaa)+len(bbb))
+	for k, v := range aaa {
+		names[k] = v
+	}
+	for k, v := range bbb {
+		names[k] = v
+	}
+	return names
+}
+
+// writeAddressesByName Given a device string, return any registers that need to be written to a
+// different address than they are read from.
+func writeAddressesByName(device string) map[int]uint16 {
+	switch strings.ToLower(device) {
+	case "solaxx1hybrid", "solaxx1hybridex":
+		return solaxX1HybridWrite
+	}
+	return nil
+}
+
+// clockByName Given a device string, return the registers used for the device clock.
+func clockByName(device string) (DateTimeRegisters, bool) {
+	switch strings.ToLower(device) {
+	case "solaxx1hybridex":
+		return solaxX1HybridClock, true
+	}
+	return DateTimeRegisters{}, false
+}
+
+// compositesByName Given a device string, return any composite values available.
+func compositesByName(device string) map[int]Composite {
+	switch strings.ToLower(device) {
+	case "solaxx1hybridex":
+		return solaxX1HybridComposites
+	}
+	return nil
+}
+
+// codeByName Return the code of the register or composite with the given name. Names are not case
+// sensitive and a Modicon code given as a string is also accepted.
+func codeByName(names map[int]string, composites map[int]Composite, name string) (int, error) {
+	if code, err := strconv.Atoi(name); err == nil {
+		return code, nil
+	}
+	name = strings.ToLower(name)
+	for code, regName := range names {
+		if regName == name {
+			return code, nil
+		}
+	}
+	for code, comp := range composites {
+		if comp.Name == name {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("No register named '%s'", name)
+}
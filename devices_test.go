@@ -0,0 +1,87 @@
+package modbusdev
+
+import (
+	"testing"
+)
+
+func TestRegisterNames(t *testing.T) {
+	for _, device := range Devices() {
+		regs, err := RegistersByName(device)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for code := range namesByName(device) {
+			if _, ck := regs[code]; !ck {
+				t.Fatalf("Name given for %d of %s, which is not a register", code, device)
+			}
+		}
+		names, err := RegisterNames(device)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := make(map[string]int)
+		for code, name := range names {
+			if name == "" {
+				t.Fatalf("Register %d of %s has no name", code, device)
+			}
+			if other, ck := seen[name]; ck {
+				t.Fatalf("Registers %d and %d of %s are both named %s", code, other, device, name)
+			}
+			seen[name] = code
+		}
+		for code, comp := range compositesByName(device) {
+			if _, ck := seen[comp.Name]; ck || comp.Name == "" {
+				t.Fatalf("Composite %d of %s does not have a unique name", code, device)
+			}
+		}
+	}
+}
+
+func TestCodeByName(t *testing.T) {
+	names, _ := RegisterNames("solaxx1hybridex")
+	comps := compositesByName("solaxx1hybridex")
+	tests := map[string]int{"pv1_power": 30011, "PV2_Power": 30012, "30003": 30003, "mac_address": 940163}
+	for name, expected := range tests {
+		code, err := codeByName(names, comps, name)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %s", name, err)
+		}
+		if code != expected {
+			t.Fatalf("Incorrect code for %s. Got %d expected %d", name, code, expected)
+		}
+	}
+	if _, err := codeByName(names, comps, "not_a_register"); err == nil {
+		t.Fatal("Expected error for unknown name")
+	}
+}
+
+func TestByName(t *testing.T) {
+	client := NewFakeClient()
+	client.Input[0x0a] = 1234
+	rdr, err := NewReader(client, "solaxx1hybrid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := rdr.ReadByName("pv1_power", false)
+	if err != nil || val.Unsigned16 != 1234 {
+		t.Fatalf("Incorrect value from ReadByName. Got %d [%v]", val.Unsigned16, err)
+	}
+	if err := rdr.Read(); err != nil {
+		t.Fatal(err)
+	}
+	val, err = rdr.GetByName("pv1_power", true)
+	if err != nil || val.Ieee32 != 1234 {
+		t.Fatalf("Incorrect value from GetByName. Got %f [%v]", val.Ieee32, err)
+	}
+
+	wrt, err := NewWriter(client, "solaxx1hybridex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wrt.WriteByName("max_export_power", 2000); err != nil {
+		t.Fatal(err)
+	}
+	if client.Holding[0x42] != 2000 {
+		t.Fatalf("Incorrect value written by WriteByName. Got %d", client.Holding[0x42])
+	}
+}
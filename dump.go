@@ -0,0 +1,109 @@
+package modbusdev
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DumpFormats The output formats supported by Dump and Readings.Format.
+var DumpFormats = []string{"table", "json", "jsonl", "csv", "markdown"}
+
+const tableFmt = "  %6d: %-40s %12s %12s %s\n"
+
+// Dump Query all defined registers and write the results to w in the given format, one of
+// table, json, jsonl (JSON lines), csv or markdown. Each register is given with the code,
+// description, raw value, factored value and units.
+func (rdr *Reader) Dump(w io.Writer, format string) error {
+	readings, err := rdr.Readings(true)
+	if err != nil {
+		return fmt.Errorf("Unable to read register data from device: %s", err)
+	}
+	return readings.Format(w, format)
+}
+
+// Format Write the values for the codes to w in the given format. If no codes are given all
+// values are written.
+func (r Readings) Format(w io.Writer, format string, codes ...int) error {
+	if len(codes) == 0 {
+		codes = r.Codes()
+	}
+	values := make([]ReadingValue, 0, len(codes))
+	for _, code := range codes {
+		if _, ck := r.Values[code]; !ck {
+			return fmt.Errorf("No value for code %d", code)
+		}
+		values = append(values, r.Value(code))
+	}
+
+	switch strings.ToLower(format) {
+	case "", "table":
+		for _, val := range values {
+			if _, err := fmt.Fprintf(w, tableFmt, val.Code, val.Description, formatRaw(val.Raw, 2),
+				formatValue(val.Value, 2), val.Units); err != nil {
+				return err
+			}
+		}
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(values)
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		for _, val := range values {
+			if err := enc.Encode(val); err != nil {
+				return err
+			}
+		}
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"code", "name", "description", "raw", "value", "units"})
+		for _, val := range values {
+			cw.Write([]string{strconv.Itoa(val.Code), val.Name, val.Description, formatRaw(val.Raw, -1),
+				formatValue(val.Value, -1), val.Units})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "markdown", "md":
+		escape := strings.NewReplacer("|", `\|`)
+		fmt.Fprintln(w, "| Code | Description | Raw | Value | Units |")
+		fmt.Fprintln(w, "|-----:|-------------|----:|------:|-------|")
+		for _, val := range values {
+			if _, err := fmt.Fprintf(w, "| %d | %s | %s | %s | %s |\n", val.Code, escape.Replace(val.Description),
+				formatRaw(val.Raw, 2), escape.Replace(formatValue(val.Value, 2)), val.Units); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Format '%s' is not known, must be one of %s", format, strings.Join(DumpFormats, ", "))
+	}
+	return nil
+}
+
+func formatRaw(raw *float64, prec int) string {
+	if raw == nil {
+		return ""
+	}
+	return formatValue(*raw, prec)
+}
+
+// formatValue Return the value as text. Numbers that are not whole are given to prec decimal
+// places, or as many as required if prec is -1.
+func formatValue(value interface{}, prec int) string {
+	switch val := value.(type) {
+	case float64:
+		if val == math.Trunc(val) {
+			prec = 0
+		}
+		return strconv.FormatFloat(val, 'f', prec, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	case string:
+		return val
+	}
+	return ""
+}
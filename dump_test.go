@@ -0,0 +1,84 @@
+package modbusdev
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func dumpReader(t *testing.T) Reader {
+	client := NewFakeClient()
+	client.Input[0x00] = 2405
+	client.Input[0x0a] = 1234
+	rdr, err := NewReader(client, "solaxx1hybrid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rdr
+}
+
+func TestDumpTable(t *testing.T) {
+	rdr := dumpReader(t)
+	var buf bytes.Buffer
+	if err := rdr.Dump(&buf, "table"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	expected := "   30001: Grid Voltage                                     2405       240.50 V"
+	if lines[0] != expected {
+		t.Fatalf("Incorrect value. Got %q expected %q", lines[0], expected)
+	}
+	if len(lines) != len(rdr.registers)+1 {
+		t.Fatalf("Incorrect value. Got %d lines expected %d", len(lines), len(rdr.registers)+1)
+	}
+}
+
+func TestDumpFormats(t *testing.T) {
+	rdr := dumpReader(t)
+	var buf bytes.Buffer
+	if err := rdr.Dump(&buf, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var values []ReadingValue
+	if err := json.Unmarshal(buf.Bytes(), &values); err != nil {
+		t.Fatal(err)
+	}
+	if values[0].Code != 30001 || *values[0].Raw != 2405 || values[0].Units != "V" || values[0].Name != "grid_voltage" {
+		t.Fatalf("Incorrect value %+v", values[0])
+	}
+
+	buf.Reset()
+	if err := rdr.Dump(&buf, "jsonl"); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != len(values) {
+		t.Fatalf("Incorrect value. Got %d lines expected %d", len(lines), len(values))
+	}
+
+	buf.Reset()
+	if err := rdr.Dump(&buf, "csv"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(rows[1], ",") != "30001,grid_voltage,Grid Voltage,2405,240.5,V" {
+		t.Fatalf("Incorrect row %v", rows[1])
+	}
+
+	buf.Reset()
+	if err := rdr.Dump(&buf, "markdown"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	if lines[2] != "| 30001 | Grid Voltage | 2405 | 240.50 | V |" {
+		t.Fatalf("Incorrect line %q", lines[2])
+	}
+
+	if err := rdr.Dump(&buf, "xml"); err == nil {
+		t.Fatal("Expected error for unknown format")
+	}
+}
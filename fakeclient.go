@@ -0,0 +1,302 @@
+package modbusdev
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/goburrow/modbus"
+)
+
+// FakeClient An in-memory modbus.Client for testing code that uses a Reader or Writer without a
+// device. Registers and coils not set read as 0. The tables can be set directly before the
+// client is used, or using the Set functions once it is in use.
+//
+// Every request is recorded and faults can be added so that matching requests fail.
+type FakeClient struct {
+	Coils          map[uint16]bool
+	DiscreteInputs map[uint16]bool
+	Input          map[uint16]uint16
+	Holding        map[uint16]uint16
+
+	mu       sync.Mutex
+	requests []FakeRequest
+	faults   []FakeFault
+}
+
+// FakeRequest A request made to a FakeClient.
+type FakeRequest struct {
+	Function byte
+	Address  uint16
+	Quantity uint16
+	// Values The data written, if any.
+	Values []byte
+	// Err The error returned, if any.
+	Err error
+}
+
+// FakeFault Details of a fault to be returned by a FakeClient. A fault matches a request when
+// the function codes match (or Function is 0) and the request includes any of the Quantity
+// registers or coils from Address (or Quantity is 0).
+//
+// Matching requests return a modbus.ModbusError with the Exception code if set, otherwise
+// FakeTimeout if Timeout is set. If Short is set the response is returned with its last byte
+// missing. If Count is set the fault only applies to that many requests.
+type FakeFault struct {
+	Function  byte
+	Address   uint16
+	Quantity  uint16
+	Exception byte
+	Timeout   bool
+	Short     bool
+	Count     int
+}
+
+// FakeTimeout The error returned by a FakeClient for a timeout fault. It is a net.Error.
+var FakeTimeout error = fakeTimeout{}
+
+type fakeTimeout struct{}
+
+func (fakeTimeout) Error() string   { return "modbus: i/o timeout" }
+func (fakeTimeout) Timeout() bool   { return true }
+func (fakeTimeout) Temporary() bool { return true }
+
+// NewFakeClient Return a FakeClient with empty tables.
+func NewFakeClient() *FakeClient {
+	return &FakeClient{Coils: make(map[uint16]bool), DiscreteInputs: make(map[uint16]bool),
+		Input: make(map[uint16]uint16), Holding: make(map[uint16]uint16)}
+}
+
+// SetInput Set the values of consecutive input registers starting at address.
+func (fc *FakeClient) SetInput(address uint16, values ...uint16) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	for n, val := range values {
+		fc.Input[address+uint16(n)] = val
+	}
+}
+
+// SetHolding Set the values of consecutive holding registers starting at address.
+func (fc *FakeClient) SetHolding(address uint16, values ...uint16) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	for n, val := range values {
+		fc.Holding[address+uint16(n)] = val
+	}
+}
+
+// GetHolding Return the value of a holding register.
+func (fc *FakeClient) GetHolding(address uint16) uint16 {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.Holding[address]
+}
+
+// AddFault Add a fault that will be returned for matching requests.
+func (fc *FakeClient) AddFault(fault FakeFault) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.faults = append(fc.faults, fault)
+}
+
+// ClearFaults Remove all faults.
+func (fc *FakeClient) ClearFaults() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.faults = nil
+}
+
+// Requests Return the requests made since the client was created or ResetRequests was called.
+func (fc *FakeClient) Requests() []FakeRequest {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return append([]FakeRequest(nil), fc.requests...)
+}
+
+// ResetRequests Clear the record of requests made.
+func (fc *FakeClient) ResetRequests() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.requests = nil
+}
+
+// ReadCoils Read the value of quantity coils, packed 8 to a byte.
+func (fc *FakeClient) ReadCoils(address, quantity uint16) ([]byte, error) {
+	return fc.request(modbus.FuncCodeReadCoils, address, quantity, nil, func() []byte {
+		return packBits(fc.Coils, address, quantity)
+	})
+}
+
+// ReadDiscreteInputs Read the value of quantity discrete inputs, packed 8 to a byte.
+func (fc *FakeClient) ReadDiscreteInputs(address, quantity uint16) ([]byte, error) {
+	return fc.request(modbus.FuncCodeReadDiscreteInputs, address, quantity, nil, func() []byte {
+		return packBits(fc.DiscreteInputs, address, quantity)
+	})
+}
+
+// WriteSingleCoil Set a coil, value must be 0xFF00 (on) or 0x0000 (off).
+func (fc *FakeClient) WriteSingleCoil(address, value uint16) ([]byte, error) {
+	values := uint16Bytes(value)
+	if value != 0xFF00 && value != 0 {
+		return fc.exception(modbus.FuncCodeWriteSingleCoil, address, 1, values, modbus.ExceptionCodeIllegalDataValue)
+	}
+	return fc.request(modbus.FuncCodeWriteSingleCoil, address, 1, values, func() []byte {
+		fc.Coils[address] = value == 0xFF00
+		return values
+	})
+}
+
+// WriteMultipleCoils Set quantity coils from the values, packed 8 to a byte.
+func (fc *FakeClient) WriteMultipleCoils(address, quantity uint16, value []byte) ([]byte, error) {
+	if len(value) < int(quantity+7)/8 {
+		return fc.exception(modbus.FuncCodeWriteMultipleCoils, address, quantity, value, modbus.ExceptionCodeIllegalDataValue)
+	}
+	return fc.request(modbus.FuncCodeWriteMultipleCoils, address, quantity, value, func() []byte {
+		for n := uint16(0); n < quantity; n++ {
+			fc.Coils[address+n] = value[n/8]&(1<<(n%8)) != 0
+		}
+		return uint16Bytes(quantity)
+	})
+}
+
+// ReadInputRegisters Read quantity input registers.
+func (fc *FakeClient) ReadInputRegisters(address, quantity uint16) ([]byte, error) {
+	return fc.request(modbus.FuncCodeReadInputRegisters, address, quantity, nil, func() []byte {
+		return registerBytes(fc.Input, address, quantity)
+	})
+}
+
+// ReadHoldingRegisters Read quantity holding registers.
+func (fc *FakeClient) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	return fc.request(modbus.FuncCodeReadHoldingRegisters, address, quantity, nil, func() []byte {
+		return registerBytes(fc.Holding, address, quantity)
+	})
+}
+
+// WriteSingleRegister Write a holding register, returning the value written.
+func (fc *FakeClient) WriteSingleRegister(address, value uint16) ([]byte, error) {
+	values := uint16Bytes(value)
+	return fc.request(modbus.FuncCodeWriteSingleRegister, address, 1, values, func() []byte {
+		fc.Holding[address] = value
+		return values
+	})
+}
+
+// WriteMultipleRegisters Write quantity holding registers, returning the quantity written.
+func (fc *FakeClient) WriteMultipleRegisters(address, quantity uint16, value []byte) ([]byte, error) {
+	if len(value) != int(quantity)*2 {
+		return fc.exception(modbus.FuncCodeWriteMultipleRegisters, address, quantity, value, modbus.ExceptionCodeIllegalDataValue)
+	}
+	return fc.request(modbus.FuncCodeWriteMultipleRegisters, address, quantity, value, func() []byte {
+		for n := uint16(0); n < quantity; n++ {
+			fc.Holding[address+n] = binary.BigEndian.Uint16(value[n*2:])
+		}
+		return uint16Bytes(quantity)
+	})
+}
+
+// ReadWriteMultipleRegisters Write holding registers and then read holding registers.
+func (fc *FakeClient) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) ([]byte, error) {
+	if len(value) != int(writeQuantity)*2 {
+		return fc.exception(modbus.FuncCodeReadWriteMultipleRegisters, writeAddress, writeQuantity, value, modbus.ExceptionCodeIllegalDataValue)
+	}
+	return fc.request(modbus.FuncCodeReadWriteMultipleRegisters, readAddress, readQuantity, value, func() []byte {
+		for n := uint16(0); n < writeQuantity; n++ {
+			fc.Holding[writeAddress+n] = binary.BigEndian.Uint16(value[n*2:])
+		}
+		return registerBytes(fc.Holding, readAddress, readQuantity)
+	})
+}
+
+// MaskWriteRegister Modify a holding register using the masks, returning the masks.
+func (fc *FakeClient) MaskWriteRegister(address, andMask, orMask uint16) ([]byte, error) {
+	values := append(uint16Bytes(andMask), uint16Bytes(orMask)...)
+	return fc.request(modbus.FuncCodeMaskWriteRegister, address, 1, values, func() []byte {
+		fc.Holding[address] = fc.Holding[address]&andMask | orMask&^andMask
+		return values
+	})
+}
+
+// ReadFIFOQueue Not supported, an illegal function exception is returned.
+func (fc *FakeClient) ReadFIFOQueue(address uint16) ([]byte, error) {
+	return fc.exception(modbus.FuncCodeReadFIFOQueue, address, 0, nil, modbus.ExceptionCodeIllegalFunction)
+}
+
+// request Record the request and, unless a fault matches, return the result of the function.
+func (fc *FakeClient) request(function byte, address, quantity uint16, values []byte, fn func() []byte) ([]byte, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	req := FakeRequest{Function: function, Address: address, Quantity: quantity,
+		Values: append([]byte(nil), values...)}
+	fault, ck := fc.fault(req)
+	switch {
+	case ck && fault.Exception != 0:
+		req.Err = &modbus.ModbusError{FunctionCode: function | 0x80, ExceptionCode: fault.Exception}
+	case ck && fault.Timeout:
+		req.Err = FakeTimeout
+	}
+	fc.requests = append(fc.requests, req)
+	if req.Err != nil {
+		return nil, req.Err
+	}
+	results := fn()
+	if ck && fault.Short && len(results) > 0 {
+		results = results[:len(results)-1]
+	}
+	return results, nil
+}
+
+// exception Record the request and return the exception.
+func (fc *FakeClient) exception(function byte, address, quantity uint16, values []byte, code byte) ([]byte, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	err := &modbus.ModbusError{FunctionCode: function | 0x80, ExceptionCode: code}
+	fc.requests = append(fc.requests, FakeRequest{Function: function, Address: address, Quantity: quantity,
+		Values: append([]byte(nil), values...), Err: err})
+	return nil, err
+}
+
+// fault Return the first fault matching the request, removing it once it has been used Count
+// times.
+func (fc *FakeClient) fault(req FakeRequest) (FakeFault, bool) {
+	for i, fault := range fc.faults {
+		if fault.Function != 0 && fault.Function != req.Function {
+			continue
+		}
+		if fault.Quantity != 0 && (int(req.Address)+int(req.Quantity) <= int(fault.Address) ||
+			int(req.Address) >= int(fault.Address)+int(fault.Quantity)) {
+			continue
+		}
+		if fault.Count > 0 {
+			fc.faults[i].Count--
+			if fc.faults[i].Count == 0 {
+				fc.faults = append(fc.faults[:i], fc.faults[i+1:]...)
+			}
+		}
+		return fault, true
+	}
+	return FakeFault{}, false
+}
+
+func registerBytes(regs map[uint16]uint16, address, quantity uint16) []byte {
+	results := make([]byte, int(quantity)*2)
+	for n := uint16(0); n < quantity; n++ {
+		binary.BigEndian.PutUint16(results[n*2:], regs[address+n])
+	}
+	return results
+}
+
+func packBits(bits map[uint16]bool, address, quantity uint16) []byte {
+	results := make([]byte, (int(quantity)+7)/8)
+	for n := uint16(0); n < quantity; n++ {
+		if bits[address+n] {
+			results[n/8] |= 1 << (n % 8)
+		}
+	}
+	return results
+}
+
+func uint16Bytes(value uint16) []byte {
+	results := make([]byte, 2)
+	binary.BigEndian.PutUint16(results, value)
+	return results
+}
@@ -0,0 +1,99 @@
+package modbusdev
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestFakeClient(t *testing.T) {
+	client := NewFakeClient()
+	client.SetInput(10, 1, 2, 3)
+	results, err := client.ReadInputRegisters(9, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(results, []byte{0, 0, 0, 1, 0, 2, 0, 3}) {
+		t.Fatalf("Incorrect value. Got %v expected [0 0 0 1 0 2 0 3]", results)
+	}
+
+	if _, err = client.WriteMultipleRegisters(5, 2, []byte{0x12, 0x34, 0x56, 0x78}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.MaskWriteRegister(5, 0xFF00, 0x00AA); err != nil {
+		t.Fatal(err)
+	}
+	if client.GetHolding(5) != 0x12AA || client.GetHolding(6) != 0x5678 {
+		t.Fatalf("Incorrect value. Got %04X %04X expected 12AA 5678", client.GetHolding(5), client.GetHolding(6))
+	}
+
+	if _, err = client.WriteMultipleCoils(0, 10, []byte{0x05, 0x02}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.WriteSingleCoil(2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if results, err = client.ReadCoils(0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(results, []byte{0x01, 0x02}) {
+		t.Fatalf("Incorrect value. Got %v expected [1 2]", results)
+	}
+	if _, err = client.WriteSingleCoil(2, 1); err == nil {
+		t.Fatal("Expected error writing an invalid coil value")
+	}
+
+	reqs := client.Requests()
+	if len(reqs) != 7 {
+		t.Fatalf("Incorrect value. Got %d requests expected 7", len(reqs))
+	}
+	if reqs[1].Function != modbus.FuncCodeWriteMultipleRegisters || reqs[1].Address != 5 || reqs[1].Quantity != 2 ||
+		!bytes.Equal(reqs[1].Values, []byte{0x12, 0x34, 0x56, 0x78}) {
+		t.Fatalf("Incorrect request recorded %+v", reqs[1])
+	}
+	if reqs[6].Err == nil {
+		t.Fatal("Error was not recorded for the invalid coil value")
+	}
+	client.ResetRequests()
+	if len(client.Requests()) != 0 {
+		t.Fatal("Requests were not reset")
+	}
+}
+
+func TestFakeClientFaults(t *testing.T) {
+	client := NewFakeClient()
+	client.AddFault(FakeFault{Function: modbus.FuncCodeReadHoldingRegisters, Address: 10, Quantity: 2,
+		Exception: modbus.ExceptionCodeIllegalDataAddress})
+	client.AddFault(FakeFault{Function: modbus.FuncCodeReadInputRegisters, Timeout: true, Count: 1})
+	client.AddFault(FakeFault{Function: modbus.FuncCodeReadInputRegisters, Short: true, Count: 1})
+
+	if _, err := client.ReadHoldingRegisters(0, 10); err != nil {
+		t.Fatalf("Fault applied to registers outside the range: %s", err)
+	}
+	_, err := client.ReadHoldingRegisters(5, 6)
+	var mbErr *modbus.ModbusError
+	if !errors.As(err, &mbErr) || mbErr.ExceptionCode != modbus.ExceptionCodeIllegalDataAddress {
+		t.Fatalf("Incorrect error. Got %v expected an illegal data address exception", err)
+	}
+
+	_, err = client.ReadInputRegisters(0, 2)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Incorrect error. Got %v expected a timeout", err)
+	}
+	results, err := client.ReadInputRegisters(0, 2)
+	if err != nil || len(results) != 3 {
+		t.Fatalf("Incorrect short response. Got %v, %v expected 3 bytes", results, err)
+	}
+	if results, err = client.ReadInputRegisters(0, 2); err != nil || len(results) != 4 {
+		t.Fatalf("Fault was not removed after Count uses. Got %v, %v", results, err)
+	}
+
+	client.ClearFaults()
+	if _, err = client.ReadHoldingRegisters(10, 1); err != nil {
+		t.Fatalf("Fault was not cleared: %s", err)
+	}
+}
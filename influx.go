@@ -0,0 +1,208 @@
+package modbusdev
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultInfluxTimeout = 10
+
+// InfluxSink A Sink that converts readings to InfluxDB line protocol. Lines are either sent to an
+// InfluxDB HTTP write endpoint (URL) or appended to a file. If neither is given they are written
+// to stdout.
+//
+// Each reading becomes a single line, with the device as the measurement (unless Measurement is
+// set), tags for the device, slave id and site, and a field for each register named from the
+// register name.
+type InfluxSink struct {
+	// URL The full write endpoint, including any query parameters, e.g.
+	// http://localhost:8086/api/v2/write?org=home&bucket=modbus or
+	// http://localhost:8086/write?db=modbus
+	URL string
+	// Token Sent as an Authorization header for InfluxDB 2.x
+	Token    string
+	Username string
+	Password string
+	// Timeout The HTTP request timeout in seconds
+	Timeout int
+	// Filename The file to append lines to. Use "-" for stdout.
+	Filename string
+
+	Measurement string
+	Site        string
+	Tags        map[string]string
+	// Registers The names (or codes) of the registers to include. All registers and composites
+	// are included if none are given.
+	Registers []string
+
+	client *http.Client
+	out    io.Writer
+	file   *os.File
+}
+
+// Open Prepare the HTTP client or open the output file.
+func (is *InfluxSink) Open() error {
+	if is.URL != "" {
+		if !strings.HasPrefix(is.URL, "http://") && !strings.HasPrefix(is.URL, "https://") {
+			return fmt.Errorf("Invalid InfluxDB URL '%s'", is.URL)
+		}
+		timeout := is.Timeout
+		if timeout <= 0 {
+			timeout = defaultInfluxTimeout
+		}
+		is.client = &http.Client{Timeout: time.Duration(timeout) * time.Second}
+		return nil
+	}
+	if is.Filename == "" || is.Filename == "-" {
+		is.out = os.Stdout
+		return nil
+	}
+	file, err := os.OpenFile(is.Filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	is.file = file
+	is.out = file
+	return nil
+}
+
+// Write Convert the readings to line protocol and send or write them.
+func (is *InfluxSink) Write(readings Readings) error {
+	line, err := is.line(readings)
+	if err != nil {
+		return err
+	}
+	if is.client != nil {
+		return is.post(line)
+	}
+	if is.out == nil {
+		return fmt.Errorf("InfluxDB sink has not been opened")
+	}
+	_, err = io.WriteString(is.out, line)
+	return err
+}
+
+// Close Close the output file, if one was opened.
+func (is *InfluxSink) Close() error {
+	is.client = nil
+	is.out = nil
+	if is.file == nil {
+		return nil
+	}
+	err := is.file.Close()
+	is.file = nil
+	return err
+}
+
+func (is *InfluxSink) post(body string) error {
+	req, err := http.NewRequest(http.MethodPost, is.URL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if is.Token != "" {
+		req.Header.Set("Authorization", "Token "+is.Token)
+	} else if is.Username != "" {
+		req.SetBasicAuth(is.Username, is.Password)
+	}
+	resp, err := is.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("InfluxDB write failed with status %s: %s", resp.Status,
+			strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// line Return the readings as a single line of line protocol, including the trailing newline.
+func (is *InfluxSink) line(readings Readings) (string, error) {
+	codes, err := readings.selectCodes(is.Registers)
+	if err != nil {
+		return "", err
+	}
+	if len(codes) == 0 {
+		return "", fmt.Errorf("No register values to write")
+	}
+
+	var buf bytes.Buffer
+	measurement := is.Measurement
+	if measurement == "" {
+		measurement = readings.Device
+	}
+	buf.WriteString(influxEscape(measurement, ", "))
+
+	tags := map[string]string{"device": readings.Device, "slave": strconv.Itoa(int(readings.SlaveID))}
+	if is.Site != "" {
+		tags["site"] = is.Site
+	}
+	for key, value := range is.Tags {
+		tags[key] = value
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	// Tags should be sorted by key for the best performance.
+	sort.Strings(keys)
+	for _, key := range keys {
+		if tags[key] == "" {
+			continue
+		}
+		fmt.Fprintf(&buf, ",%s=%s", influxEscape(key, ",= "), influxEscape(tags[key], ",= "))
+	}
+
+	for i, code := range codes {
+		sep := ","
+		if i == 0 {
+			sep = " "
+		}
+		name := readings.Name(code)
+		if name == "" {
+			name = strconv.Itoa(code)
+		}
+		buf.WriteString(sep + influxEscape(name, ",= ") + "=" + influxFieldValue(readings, code))
+	}
+
+	tm := readings.Time
+	if tm.IsZero() {
+		tm = time.Now()
+	}
+	fmt.Fprintf(&buf, " %d\n", tm.UnixNano())
+	return buf.String(), nil
+}
+
+// influxFieldValue Return the value for the code formatted as a line protocol field value. Coils
+// are booleans, composites are strings and all other registers are floats.
+func influxFieldValue(readings Readings, code int) string {
+	if _, ck := readings.Composites[code]; ck {
+		text := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(readings.Values[code].Text)
+		return `"` + text + `"`
+	}
+	if readings.Registers[code].Format == "coil" {
+		return strconv.FormatBool(readings.Values[code].Coil)
+	}
+	return strconv.FormatFloat(readings.FactoredValue(code), 'f', -1, 64)
+}
+
+// influxEscape Escape the special characters with a backslash.
+func influxEscape(s, special string) string {
+	var sb strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(special, c) {
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
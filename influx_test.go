@@ -0,0 +1,113 @@
+package modbusdev
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func influxReadings(t *testing.T) Readings {
+	client := NewFakeClient()
+	client.Input[0x0a] = 1234
+	client.Input[0x02] = 2405
+	rdr, err := NewReader(client, "solaxx1hybrid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr.SetSlaveID(3)
+	readings, err := rdr.Readings(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings.Time = time.Unix(1600000000, 0)
+	return readings
+}
+
+func TestInfluxLine(t *testing.T) {
+	is := InfluxSink{Site: "home office", Registers: []string{"pv1_power", "30003"}}
+	line, err := is.line(influxReadings(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "solaxx1hybrid,device=solaxx1hybrid,site=home\\ office,slave=3 pv1_power=1234,inverter_power=2405 1600000000000000000\n"
+	if line != expected {
+		t.Fatalf("Incorrect line. Got %q expected %q", line, expected)
+	}
+
+	is.Registers = []string{"not_a_register"}
+	if _, err := is.line(influxReadings(t)); err == nil {
+		t.Fatal("Expected error for unknown register")
+	}
+}
+
+func TestInfluxEscape(t *testing.T) {
+	if got := influxEscape("a b,c=d", ",= "); got != `a\ b\,c\=d` {
+		t.Fatalf("Incorrect value. Got %s expected a\\ b\\,c\\=d", got)
+	}
+}
+
+func TestInfluxHTTP(t *testing.T) {
+	var body, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		auth = r.Header.Get("Authorization")
+		if r.URL.Query().Get("bucket") != "modbus" {
+			http.Error(w, "bucket not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	is := &InfluxSink{URL: srv.URL + "/api/v2/write?org=home&bucket=modbus", Token: "secret",
+		Registers: []string{"pv1_power"}}
+	if err := is.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer is.Close()
+	if err := is.Write(influxReadings(t)); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Token secret" {
+		t.Fatalf("Incorrect authorization. Got %s expected Token secret", auth)
+	}
+	if !strings.HasPrefix(body, "solaxx1hybrid,device=solaxx1hybrid,slave=3 pv1_power=1234 ") {
+		t.Fatalf("Incorrect body: %s", body)
+	}
+
+	is.URL = srv.URL + "/api/v2/write?org=home&bucket=missing"
+	err := is.Write(influxReadings(t))
+	if err == nil || !strings.Contains(err.Error(), "bucket not found") {
+		t.Fatalf("Expected error for missing bucket, got %v", err)
+	}
+}
+
+func TestInfluxFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "readings.lp")
+	is := &InfluxSink{Filename: filename, Measurement: "inverter", Registers: []string{"pv1_power"}}
+	if err := is.Open(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := is.Write(influxReadings(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := is.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "inverter,device=solaxx1hybrid") {
+		t.Fatalf("Incorrect file contents: %s", data)
+	}
+}
@@ -0,0 +1,355 @@
+package modbusdev
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	defaultMQTTPrefix      = "modbusdev"
+	defaultDiscoveryPrefix = "homeassistant"
+	mqttTimeout            = 10 * time.Second
+)
+
+// MQTTSink A Sink that publishes each register value to its own topic, prefix/device/register.
+//
+// When Discovery is set, retained Home Assistant discovery payloads are published for each
+// register the first time a device is written. Registers listed in Commands are also made
+// available as number entities, with values published to prefix/device/register/set written to
+// the device using the Writer given to SetWriter.
+type MQTTSink struct {
+	// Broker The address of the broker, e.g. tcp://localhost:1883
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// Prefix The start of every topic, modbusdev if not set.
+	Prefix string
+	// DeviceID Used in topics in place of the device name, which allows several of the same
+	// device to be published.
+	DeviceID string
+	QoS      byte
+	Retain   bool
+	// Registers The names (or codes) of the registers to publish. All registers and composites
+	// are published if none are given.
+	Registers []string
+
+	Discovery       bool
+	DiscoveryPrefix string
+	// Commands The names (or codes) of the holding registers that can be written by publishing
+	// to the command topic.
+	Commands []string
+
+	mu         sync.Mutex
+	client     mqtt.Client
+	writer     *Writer
+	discovered map[string]bool
+	commands   map[string]mqttCommand
+}
+
+type mqttCommand struct {
+	code int
+	reg  Register
+}
+
+// SetWriter Set the Writer used for values received on command topics. Commands are ignored
+// until a Writer has been set.
+func (ms *MQTTSink) SetWriter(wrt *Writer) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.writer = wrt
+}
+
+// Open Connect to the broker.
+func (ms *MQTTSink) Open() error {
+	if ms.Broker == "" {
+		return fmt.Errorf("No MQTT broker given")
+	}
+	if ms.QoS > 2 {
+		return fmt.Errorf("Invalid QoS %d, must be 0, 1 or 2", ms.QoS)
+	}
+	if ms.Prefix == "" {
+		ms.Prefix = defaultMQTTPrefix
+	}
+	if ms.DiscoveryPrefix == "" {
+		ms.DiscoveryPrefix = defaultDiscoveryPrefix
+	}
+	clientID := ms.ClientID
+	if clientID == "" {
+		clientID = fmt.Sprintf("modbusdev-%d", time.Now().UnixNano())
+	}
+	opts := mqtt.NewClientOptions().AddBroker(ms.Broker).SetClientID(clientID).
+		SetUsername(ms.Username).SetPassword(ms.Password).SetAutoReconnect(true).
+		SetConnectTimeout(mqttTimeout).SetOnConnectHandler(ms.subscribe)
+
+	ms.client = mqtt.NewClient(opts)
+	token := ms.client.Connect()
+	if !token.WaitTimeout(mqttTimeout) {
+		return fmt.Errorf("Timed out connecting to MQTT broker %s", ms.Broker)
+	}
+	return token.Error()
+}
+
+// Write Publish the readings, along with the discovery payloads if this is the first time the
+// device has been seen.
+func (ms *MQTTSink) Write(readings Readings) error {
+	if ms.client == nil {
+		return fmt.Errorf("MQTT sink has not been opened")
+	}
+	codes, err := readings.selectCodes(ms.Registers)
+	if err != nil {
+		return err
+	}
+	node := ms.nodeID(readings)
+
+	if err = ms.discover(node, readings, codes); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		topic := ms.stateTopic(node, readings.Name(code), code)
+		if err = ms.publish(topic, ms.Retain, mqttState(readings, code)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close Disconnect from the broker.
+func (ms *MQTTSink) Close() error {
+	if ms.client != nil {
+		ms.client.Disconnect(250)
+		ms.client = nil
+	}
+	return nil
+}
+
+func (ms *MQTTSink) nodeID(readings Readings) string {
+	if ms.DeviceID != "" {
+		return ms.DeviceID
+	}
+	return readings.Device
+}
+
+func (ms *MQTTSink) stateTopic(node, name string, code int) string {
+	if name == "" {
+		name = strconv.Itoa(code)
+	}
+	return ms.Prefix + "/" + node + "/" + name
+}
+
+func (ms *MQTTSink) publish(topic string, retained bool, payload string) error {
+	token := ms.client.Publish(topic, ms.QoS, retained, payload)
+	if !token.WaitTimeout(mqttTimeout) {
+		return fmt.Errorf("Timed out publishing to %s", topic)
+	}
+	return token.Error()
+}
+
+// discover Publish the Home Assistant discovery payloads and subscribe to the command topics
+// the first time a device is written.
+func (ms *MQTTSink) discover(node string, readings Readings, codes []int) (err error) {
+	ms.mu.Lock()
+	if ms.discovered[node] {
+		ms.mu.Unlock()
+		return nil
+	}
+	if ms.discovered == nil {
+		ms.discovered = make(map[string]bool)
+	}
+	ms.discovered[node] = true
+	ms.mu.Unlock()
+	defer func() {
+		if err != nil {
+			ms.mu.Lock()
+			delete(ms.discovered, node)
+			ms.mu.Unlock()
+		}
+	}()
+
+	commands := make(map[string]mqttCommand)
+	for _, name := range ms.Commands {
+		code, err := readings.code(name)
+		if err != nil {
+			return err
+		}
+		reg, ck := readings.Registers[code]
+		if !ck || getRegisterType(code) != 4 || !reg.writable() {
+			return fmt.Errorf("Register %s cannot be written", name)
+		}
+		commands[ms.stateTopic(node, readings.Name(code), code)+"/set"] = mqttCommand{code, reg}
+	}
+
+	if ms.Discovery {
+		for _, code := range codes {
+			component, payload := ms.discoveryPayload(node, readings, code)
+			cmdTopic := payload["state_topic"].(string) + "/set"
+			if cmd, ck := commands[cmdTopic]; ck {
+				component = "number"
+				payload["command_topic"] = cmdTopic
+				payload["mode"] = "box"
+				payload["min"], payload["max"] = cmd.reg.limits()
+				payload["step"] = cmd.reg.Factor
+				delete(payload, "state_class")
+			}
+			data, err := json.Marshal(payload)
+			if err != nil {
+				return err
+			}
+			topic := fmt.Sprintf("%s/%s/%s/%s/config", ms.DiscoveryPrefix, component, node,
+				payload["object_id"])
+			if err = ms.publish(topic, true, string(data)); err != nil {
+				return err
+			}
+		}
+	}
+
+	ms.mu.Lock()
+	if ms.commands == nil {
+		ms.commands = make(map[string]mqttCommand)
+	}
+	for topic, cmd := range commands {
+		ms.commands[topic] = cmd
+	}
+	ms.mu.Unlock()
+	for topic := range commands {
+		token := ms.client.Subscribe(topic, ms.QoS, ms.command)
+		if !token.WaitTimeout(mqttTimeout) {
+			return fmt.Errorf("Timed out subscribing to %s", topic)
+		}
+		if err := token.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subscribe Subscribe to the command topics again after reconnecting.
+func (ms *MQTTSink) subscribe(client mqtt.Client) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for topic := range ms.commands {
+		client.Subscribe(topic, ms.QoS, ms.command)
+	}
+}
+
+// command Write a value received on a command topic to the device. The lock is only held while
+// looking up the command, not while writing to the device.
+func (ms *MQTTSink) command(client mqtt.Client, msg mqtt.Message) {
+	ms.mu.Lock()
+	cmd, ck := ms.commands[msg.Topic()]
+	writer := ms.writer
+	ms.mu.Unlock()
+	if !ck {
+		return
+	}
+	if writer == nil {
+		log.Printf("Ignoring command on %s as no writer has been set", msg.Topic())
+		return
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(string(msg.Payload())), 64)
+	if err != nil {
+		log.Printf("Invalid value '%s' received on %s", msg.Payload(), msg.Topic())
+		return
+	}
+	if err = writer.WriteFactored(cmd.code, value); err != nil {
+		log.Printf("Unable to write %s to %s: %s", msg.Payload(), cmd.reg.Description, err)
+	}
+}
+
+// discoveryPayload Return the Home Assistant component and discovery payload for the code.
+func (ms *MQTTSink) discoveryPayload(node string, readings Readings, code int) (string, map[string]interface{}) {
+	name := readings.Name(code)
+	if name == "" {
+		name = strconv.Itoa(code)
+	}
+	payload := map[string]interface{}{
+		"name":        readings.Description(code),
+		"object_id":   node + "_" + name,
+		"unique_id":   node + "_" + name,
+		"state_topic": ms.stateTopic(node, name, code),
+		"device": map[string]interface{}{
+			"identifiers": []string{node},
+			"name":        node,
+			"model":       readings.Device,
+		},
+	}
+	if _, ck := readings.Composites[code]; ck {
+		return "sensor", payload
+	}
+	reg := readings.Registers[code]
+	if reg.Format == "coil" {
+		return "binary_sensor", payload
+	}
+	if reg.Units != "" {
+		payload["unit_of_measurement"] = haUnits(reg.Units)
+	}
+	deviceClass, stateClass := haClasses(reg, name)
+	if deviceClass != "" {
+		payload["device_class"] = deviceClass
+	}
+	payload["state_class"] = stateClass
+	return "sensor", payload
+}
+
+// haUnits Return the unit of measurement Home Assistant expects for the register units.
+func haUnits(units string) string {
+	switch units {
+	case "C":
+		return "°C"
+	}
+	return units
+}
+
+// haClasses Return the Home Assistant device and state classes for the register with the name.
+func haClasses(reg Register, name string) (string, string) {
+	switch reg.Units {
+	case "W", "kW":
+		return "power", "measurement"
+	case "V":
+		return "voltage", "measurement"
+	case "A", "Amps":
+		return "current", "measurement"
+	case "Hz":
+		return "frequency", "measurement"
+	case "VA":
+		return "apparent_power", "measurement"
+	case "VAr":
+		return "reactive_power", "measurement"
+	case "kWh":
+		return "energy", "total_increasing"
+	case "kVArh":
+		return "", "total_increasing"
+	case "C":
+		return "temperature", "measurement"
+	case "%":
+		if strings.Contains(name, "battery") {
+			return "battery", "measurement"
+		}
+	case "":
+		if name == "power_factor" {
+			return "power_factor", "measurement"
+		}
+	}
+	return "", "measurement"
+}
+
+// mqttState Return the value for the code as published to the state topic.
+func mqttState(readings Readings, code int) string {
+	if _, ck := readings.Composites[code]; ck {
+		return readings.Values[code].Text
+	}
+	if readings.Registers[code].Format == "coil" {
+		if readings.Values[code].Coil {
+			return "ON"
+		}
+		return "OFF"
+	}
+	return strconv.FormatFloat(readings.FactoredValue(code), 'f', -1, 64)
+}
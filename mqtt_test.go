@@ -0,0 +1,263 @@
+package modbusdev
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBroker A minimal MQTT 3.1.1 broker supporting QoS 0 and 1 publishing, subscriptions and
+// retained messages, which is enough to test the MQTT sink.
+type testBroker struct {
+	listener net.Listener
+	mu       sync.Mutex
+	retained map[string][]byte
+	messages map[string][]byte
+	subs     map[net.Conn][]string
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb := &testBroker{listener: listener, retained: make(map[string][]byte),
+		messages: make(map[string][]byte), subs: make(map[net.Conn][]string)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go tb.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return tb
+}
+
+func (tb *testBroker) url() string {
+	return "tcp://" + tb.listener.Addr().String()
+}
+
+func (tb *testBroker) serve(conn net.Conn) {
+	defer func() {
+		tb.mu.Lock()
+		delete(tb.subs, conn)
+		tb.mu.Unlock()
+		conn.Close()
+	}()
+	rdr := bufio.NewReader(conn)
+	for {
+		header, err := rdr.ReadByte()
+		if err != nil {
+			return
+		}
+		length, mult := 0, 1
+		for {
+			b, err := rdr.ReadByte()
+			if err != nil {
+				return
+			}
+			length += int(b&0x7f) * mult
+			if b&0x80 == 0 {
+				break
+			}
+			mult *= 128
+		}
+		body := make([]byte, length)
+		if _, err = io.ReadFull(rdr, body); err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case 1: // CONNECT
+			conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		case 3: // PUBLISH
+			qos := (header >> 1) & 3
+			topicLen := int(binary.BigEndian.Uint16(body))
+			topic := string(body[2 : 2+topicLen])
+			payload := body[2+topicLen:]
+			if qos > 0 {
+				conn.Write([]byte{0x40, 0x02, payload[0], payload[1]})
+				payload = payload[2:]
+			}
+			tb.publish(topic, payload, header&1 == 1)
+		case 8: // SUBSCRIBE
+			var filters []string
+			for pos := 2; pos < len(body); {
+				filterLen := int(binary.BigEndian.Uint16(body[pos:]))
+				filters = append(filters, string(body[pos+2:pos+2+filterLen]))
+				pos += 3 + filterLen
+			}
+			conn.Write(append([]byte{0x90, byte(2 + len(filters)), body[0], body[1]}, make([]byte, len(filters))...))
+			tb.mu.Lock()
+			tb.subs[conn] = append(tb.subs[conn], filters...)
+			for topic, payload := range tb.retained {
+				for _, filter := range filters {
+					if filter == topic {
+						conn.Write(publishPacket(topic, payload))
+					}
+				}
+			}
+			tb.mu.Unlock()
+		case 12: // PINGREQ
+			conn.Write([]byte{0xd0, 0x00})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func (tb *testBroker) publish(topic string, payload []byte, retain bool) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.messages[topic] = payload
+	if retain {
+		tb.retained[topic] = payload
+	}
+	for conn, filters := range tb.subs {
+		for _, filter := range filters {
+			if filter == topic {
+				conn.Write(publishPacket(topic, payload))
+			}
+		}
+	}
+}
+
+func (tb *testBroker) message(topic string) ([]byte, bool) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	payload, ck := tb.messages[topic]
+	return payload, ck
+}
+
+func publishPacket(topic string, payload []byte) []byte {
+	length := 2 + len(topic) + len(payload)
+	pkt := []byte{0x30}
+	for {
+		b := byte(length % 128)
+		if length /= 128; length > 0 {
+			b |= 0x80
+		}
+		pkt = append(pkt, b)
+		if length == 0 {
+			break
+		}
+	}
+	pkt = append(pkt, byte(len(topic)>>8), byte(len(topic)))
+	pkt = append(pkt, topic...)
+	return append(pkt, payload...)
+}
+
+type chanAudit struct {
+	records chan AuditRecord
+}
+
+func (ca *chanAudit) Audit(record AuditRecord) error {
+	ca.records <- record
+	return nil
+}
+
+func TestMQTTSink(t *testing.T) {
+	broker := newTestBroker(t)
+	client := NewFakeClient()
+	client.Input[0x0a] = 1234
+	client.Holding[0xB6] = 3000
+	client.Holding[0xBA] = 3680
+	rdr, err := NewReader(client, "solaxx1hybridex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrt, err := NewWriter(client, "solaxx1hybridex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings, err := rdr.Readings(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ms := &MQTTSink{Broker: broker.url(), DeviceID: "inverter", Discovery: true, QoS: 1,
+		Registers: []string{"pv1_power", "max_export_power", "mac_address"}, Commands: []string{"max_export_power"}}
+	audit := &chanAudit{make(chan AuditRecord, 1)}
+	wrt.SetAudit(audit)
+	ms.SetWriter(&wrt)
+	if err := ms.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer ms.Close()
+	if err := ms.Write(readings); err != nil {
+		t.Fatal(err)
+	}
+
+	if payload, _ := broker.message("modbusdev/inverter/pv1_power"); string(payload) != "1234" {
+		t.Fatalf("Incorrect value. Got %s expected 1234", payload)
+	}
+	if payload, _ := broker.message("modbusdev/inverter/mac_address"); string(payload) != "00:00:00:00:00:00" {
+		t.Fatalf("Incorrect value. Got %s expected 00:00:00:00:00:00", payload)
+	}
+
+	var discovery map[string]interface{}
+	payload, ck := broker.message("homeassistant/sensor/inverter/inverter_pv1_power/config")
+	if !ck {
+		t.Fatal("No discovery payload for pv1_power")
+	}
+	if err := json.Unmarshal(payload, &discovery); err != nil {
+		t.Fatal(err)
+	}
+	if discovery["device_class"] != "power" || discovery["unit_of_measurement"] != "W" ||
+		discovery["state_class"] != "measurement" || discovery["state_topic"] != "modbusdev/inverter/pv1_power" {
+		t.Fatalf("Incorrect discovery payload %s", payload)
+	}
+	broker.mu.Lock()
+	_, ck = broker.retained["homeassistant/number/inverter/inverter_max_export_power/config"]
+	broker.mu.Unlock()
+	if !ck {
+		t.Fatal("No retained number discovery payload for max_export_power")
+	}
+
+	broker.publish("modbusdev/inverter/max_export_power/set", []byte("2500"), false)
+	select {
+	case record := <-audit.records:
+		if record.Code != 40183 || record.Result != "ok" || client.Holding[0x42] != 2500 {
+			t.Fatalf("Incorrect write from command %+v", record)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Command was not written to the device")
+	}
+}
+
+func TestHAClasses(t *testing.T) {
+	tests := []struct {
+		reg         Register
+		name        string
+		deviceClass string
+		stateClass  string
+	}{
+		{sdm230[30073], "import_active_energy", "energy", "total_increasing"},
+		{sdm230[30031], "power_factor", "power_factor", "measurement"},
+		{solaxX1Hybrid[30029], "battery_capacity", "battery", "measurement"},
+		{solaxX1HybridEx[40135], "seconds", "", "measurement"},
+	}
+	for _, tst := range tests {
+		deviceClass, stateClass := haClasses(tst.reg, tst.name)
+		if deviceClass != tst.deviceClass || stateClass != tst.stateClass {
+			t.Fatalf("Incorrect value for %s. Got %s, %s expected %s, %s", tst.name, deviceClass,
+				stateClass, tst.deviceClass, tst.stateClass)
+		}
+	}
+	if units := haUnits(solaxX1Hybrid[30009].Units); units != "°C" {
+		t.Fatalf("Incorrect units for temperature. Got %s expected °C", units)
+	}
+	if !strings.HasPrefix(mqttState(Readings{Registers: map[int]Register{1: {Format: "coil"}},
+		Values: map[int]Value{1: {Coil: true}}}, 1), "ON") {
+		t.Fatal("Incorrect state for coil")
+	}
+}
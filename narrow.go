@@ -0,0 +1,68 @@
+package modbusdev
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Columns used when storing readings in narrow mode, in the order values are supplied.
+var narrowColumns = []string{"time", "device", "slave_id", "code", "description", "raw", "value", "units"}
+
+func narrowTableSQL(table string) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (time TIMESTAMP WITH TIME ZONE NOT NULL, "+
+		"device TEXT NOT NULL, slave_id SMALLINT, code INTEGER NOT NULL, description TEXT, "+
+		"raw DOUBLE PRECISION, value DOUBLE PRECISION, units TEXT)", table)
+}
+
+// narrowCodes Return the codes that should be stored for the readings. If fields are configured
+// only their codes are used, otherwise every register in the readings is used. Composite values
+// are not stored.
+func narrowCodes(readings Readings, fields []DatabaseField) []int {
+	var codes []int
+	if len(fields) > 0 {
+		for _, fld := range fields {
+			if _, ck := readings.Registers[fld.Code]; ck {
+				codes = append(codes, fld.Code)
+			}
+		}
+		return codes
+	}
+	for code := range readings.Values {
+		if _, ck := readings.Registers[code]; ck {
+			codes = append(codes, code)
+		}
+	}
+	sort.Ints(codes)
+	return codes
+}
+
+// narrowRows Return the values for each row, one per register.
+func narrowRows(readings Readings, fields []DatabaseField) [][]interface{} {
+	codes := narrowCodes(readings, fields)
+	rows := make([][]interface{}, len(codes))
+	for i, code := range codes {
+		rows[i] = []interface{}{readings.Time, readings.Device, int(readings.SlaveID), code,
+			readings.Description(code), readings.Raw(code), readings.FactoredValue(code), readings.Units(code)}
+	}
+	return rows
+}
+
+// narrowInsert Return a single statement that inserts one row per register, along with the
+// arguments for the statement.
+func narrowInsert(table string, readings Readings, fields []DatabaseField) (string, []interface{}) {
+	return multiInsert(table, narrowColumns, narrowRows(readings, fields))
+}
+
+// writeNarrow Store the readings as one row per register.
+func (dbC *DatabaseConnection) writeNarrow(ctx context.Context, readings Readings) error {
+	rows := narrowRows(readings, dbC.Fields)
+	if len(rows) == 0 {
+		return fmt.Errorf("No register values to store")
+	}
+	if dbC.batch != nil {
+		return dbC.batch.add(rows...)
+	}
+	qry, args := multiInsert(dbC.Table, narrowColumns, rows)
+	return dbC.exec(ctx, qry, args...)
+}
@@ -0,0 +1,46 @@
+package modbusdev
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNarrowInsert(t *testing.T) {
+	regs, _ := RegistersByName("solaxx1hybrid")
+	tm := time.Date(2021, 2, 15, 12, 0, 0, 0, time.UTC)
+	readings := Readings{
+		Device:    "solaxx1hybrid",
+		SlaveID:   1,
+		Time:      tm,
+		Registers: regs,
+		Values:    map[int]Value{30001: {Unsigned16: 2401}, 30011: {Unsigned16: 1500}},
+	}
+	qry, args := narrowInsert("readings", readings, nil)
+	if qry != "INSERT INTO readings (time, device, slave_id, code, description, raw, value, units) VALUES ($1,$2,$3,$4,$5,$6,$7,$8),($9,$10,$11,$12,$13,$14,$15,$16)" {
+		t.Fatalf("Incorrect query: %s", qry)
+	}
+	if len(args) != 16 {
+		t.Fatalf("Incorrect number of args, %d", len(args))
+	}
+	if args[0] != tm || args[1] != "solaxx1hybrid" || args[3] != 30001 || args[4] != "Grid Voltage" || args[5] != 2401.0 || args[7] != "V" {
+		t.Fatalf("Incorrect args for first row: %v", args[:8])
+	}
+	if v := args[6].(float64); v < 240.09 || v > 240.11 {
+		t.Fatalf("Incorrect factored value %f", v)
+	}
+
+	_, args = narrowInsert("readings", readings, []DatabaseField{{Name: "pv1", Code: 30011}})
+	if len(args) != 8 || args[3] != 30011 {
+		t.Fatalf("Fields not used to select registers: %v", args)
+	}
+}
+
+func TestReadingsRawFactored(t *testing.T) {
+	regs, _ := RegistersByName("solaxx1hybrid")
+	val := Value{Signed16: -25}
+	regs[30022].applyFactor(&val)
+	readings := Readings{Registers: regs, Values: map[int]Value{30022: val}, Factored: true}
+	if readings.Raw(30022) != -25 || readings.FactoredValue(30022) != -2.5 {
+		t.Fatalf("Incorrect values, raw %f factored %f", readings.Raw(30022), readings.FactoredValue(30022))
+	}
+}
@@ -0,0 +1,257 @@
+package modbusdev
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultInterval = 10
+
+// PollerConfig The configuration of a Poller, usually loaded from a JSON file.
+//
+//	{
+//	    "Connections": [{"Name": "inverter", "TCP": "192.168.1.100:502"}],
+//	    "Devices": [{"Name": "solax", "Connection": "inverter", "Device": "solaxx1hybrid", "Interval": 5}],
+//	    "Sinks": [{"type": "sqlite", "config": {...}}],
+//	    "HealthAddress": ":8080"
+//	}
+type PollerConfig struct {
+	Connections []ConnectionConfig
+	Devices     []PollDevice
+	Sinks       []SinkConfig
+	// HealthAddress If set, the address the health status is served on.
+	HealthAddress string
+}
+
+// PollDevice A device to be polled.
+type PollDevice struct {
+	Name       string
+	Connection string
+	Device     string
+	// SlaveID The slave address of the device, 1 if not set as 0 is the broadcast address.
+	SlaveID byte
+	// Interval Seconds between polls, 10 if not set.
+	Interval float64
+	// Raw Set to pass the readings to the sinks without the factors applied.
+	Raw bool
+}
+
+// DeviceHealth The status of a device being polled. LastPoll is nil until the device has been
+// polled successfully.
+type DeviceHealth struct {
+	Name      string     `json:"name"`
+	Healthy   bool       `json:"healthy"`
+	LastPoll  *time.Time `json:"last_poll,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	Polls     uint64     `json:"polls"`
+	Errors    uint64     `json:"errors"`
+}
+
+// HealthStatus The status of a Poller. It is healthy if all devices are healthy.
+type HealthStatus struct {
+	Healthy bool           `json:"healthy"`
+	Started time.Time      `json:"started"`
+	Devices []DeviceHealth `json:"devices"`
+}
+
+// Poller Polls a number of devices, each at its own interval, and writes the readings to the
+// configured sinks. Devices that share a connection are never accessed at the same time.
+type Poller struct {
+	cfg     PollerConfig
+	mu      sync.Mutex
+	sinkMu  sync.Mutex
+	started time.Time
+	health  map[string]*DeviceHealth
+	sinks   Sinks
+}
+
+// LoadPollerConfig Read the configuration from a JSON file.
+func LoadPollerConfig(filename string) (cfg PollerConfig, err error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		err = fmt.Errorf("Unable to parse %s: %s", filename, err)
+	}
+	return
+}
+
+// NewPoller Check the configuration and return a Poller ready to be run.
+func NewPoller(cfg PollerConfig) (*Poller, error) {
+	conns := make(map[string]bool)
+	for _, conn := range cfg.Connections {
+		if conn.Name == "" || conns[conn.Name] {
+			return nil, fmt.Errorf("Each connection must have a unique name")
+		}
+		if (conn.TCP == "") == (conn.Serial == "") {
+			return nil, fmt.Errorf("Connection %s must have either TCP or Serial set", conn.Name)
+		}
+		conns[conn.Name] = true
+	}
+	if len(cfg.Devices) == 0 {
+		return nil, fmt.Errorf("No devices to poll")
+	}
+	pl := &Poller{cfg: cfg, health: make(map[string]*DeviceHealth)}
+	for i, dev := range cfg.Devices {
+		if dev.Name == "" {
+			dev.Name = dev.Device
+			cfg.Devices[i].Name = dev.Name
+		}
+		if dev.SlaveID == 0 {
+			cfg.Devices[i].SlaveID = 1
+		}
+		if _, ck := pl.health[dev.Name]; ck {
+			return nil, fmt.Errorf("Device name %s is used more than once", dev.Name)
+		}
+		if !conns[dev.Connection] {
+			return nil, fmt.Errorf("Connection '%s' for device %s is not known", dev.Connection, dev.Name)
+		}
+		if _, err := RegistersByName(dev.Device); err != nil {
+			return nil, err
+		}
+		if dev.Interval < 0 {
+			return nil, fmt.Errorf("Invalid interval for device %s", dev.Name)
+		}
+		pl.health[dev.Name] = &DeviceHealth{Name: dev.Name}
+	}
+	sinks, err := NewSinks(cfg.Sinks)
+	if err != nil {
+		return nil, err
+	}
+	pl.sinks = sinks
+	return pl, nil
+}
+
+// Run Open the connections and sinks and poll the devices until the context is cancelled. The
+// sinks and connections are closed before returning.
+func (pl *Poller) Run(ctx context.Context) error {
+	conns := make(map[string]*modbusConnection)
+	defer func() {
+		for _, conn := range conns {
+			conn.close()
+		}
+	}()
+	for _, cc := range pl.cfg.Connections {
+		conn, err := dial(cc)
+		if conn == nil {
+			return fmt.Errorf("Unable to open connection %s: %s", cc.Name, err)
+		}
+		if err != nil {
+			log.Printf("Unable to open connection %s, will retry: %s", cc.Name, err)
+		}
+		conns[cc.Name] = conn
+	}
+	if err := pl.sinks.Open(); err != nil {
+		return err
+	}
+	defer pl.sinks.Close()
+
+	pl.mu.Lock()
+	pl.started = time.Now()
+	pl.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, dev := range pl.cfg.Devices {
+		conn := conns[dev.Connection]
+		rdr, err := NewReader(conn.client, dev.Device)
+		if err != nil {
+			return err
+		}
+		rdr.SetSlaveID(dev.SlaveID)
+		wg.Add(1)
+		go func(dev PollDevice, rdr Reader) {
+			defer wg.Done()
+			pl.poll(ctx, dev, &rdr, conn)
+		}(dev, rdr)
+	}
+	wg.Wait()
+	return nil
+}
+
+// poll Poll the device at the configured interval until the context is cancelled.
+func (pl *Poller) poll(ctx context.Context, dev PollDevice, rdr *Reader, conn *modbusConnection) {
+	interval := dev.Interval
+	if interval == 0 {
+		interval = defaultInterval
+	}
+	ticker := time.NewTicker(time.Duration(interval * float64(time.Second)))
+	defer ticker.Stop()
+	for {
+		conn.mu.Lock()
+		conn.setSlave(dev.SlaveID)
+		readings, err := rdr.Readings(!dev.Raw)
+		conn.mu.Unlock()
+		if err == nil {
+			pl.sinkMu.Lock()
+			err = pl.sinks.Write(readings)
+			pl.sinkMu.Unlock()
+		} else {
+			log.Printf("Unable to read %s: %s", dev.Name, err)
+		}
+		pl.record(dev.Name, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (pl *Poller) record(name string, err error) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	health := pl.health[name]
+	health.Polls++
+	if err != nil {
+		health.Errors++
+		health.LastError = err.Error()
+		return
+	}
+	now := time.Now()
+	health.LastPoll = &now
+	health.LastError = ""
+}
+
+// Health Return the current status. A device is healthy if it has been polled successfully
+// within the last 3 intervals.
+func (pl *Poller) Health() HealthStatus {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	status := HealthStatus{Healthy: true, Started: pl.started}
+	for _, dev := range pl.cfg.Devices {
+		health := *pl.health[dev.Name]
+		interval := dev.Interval
+		if interval == 0 {
+			interval = defaultInterval
+		}
+		limit := time.Duration(3 * interval * float64(time.Second))
+		health.Healthy = health.LastPoll != nil && time.Since(*health.LastPoll) < limit
+		if health.LastPoll == nil && !pl.started.IsZero() && time.Since(pl.started) < limit && health.Errors == 0 {
+			// Still waiting for the first poll to complete.
+			health.Healthy = true
+		}
+		status.Healthy = status.Healthy && health.Healthy
+		status.Devices = append(status.Devices, health)
+	}
+	sort.Slice(status.Devices, func(i, j int) bool { return status.Devices[i].Name < status.Devices[j].Name })
+	return status
+}
+
+// ServeHTTP Return the health status as JSON, with a 503 status if not healthy.
+func (pl *Poller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := pl.Health()
+	code := http.StatusOK
+	if !status.Healthy {
+		code = http.StatusServiceUnavailable
+	}
+	apiJSON(w, code, status)
+}
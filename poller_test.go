@@ -0,0 +1,99 @@
+package modbusdev
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPollerConfig(t *testing.T) {
+	tests := []struct {
+		cfg PollerConfig
+		err string
+	}{
+		{PollerConfig{}, "No devices to poll"},
+		{PollerConfig{Connections: []ConnectionConfig{{Name: "a"}}}, "Connection a must have either TCP or Serial set"},
+		{PollerConfig{Connections: []ConnectionConfig{{Name: "a", TCP: "h:502"}, {Name: "a", TCP: "h:503"}}},
+			"Each connection must have a unique name"},
+		{PollerConfig{Connections: []ConnectionConfig{{Name: "a", TCP: "h:502"}},
+			Devices: []PollDevice{{Connection: "b", Device: "sdm230"}}}, "Connection 'b' for device sdm230 is not known"},
+		{PollerConfig{Connections: []ConnectionConfig{{Name: "a", TCP: "h:502"}},
+			Devices: []PollDevice{{Connection: "a", Device: "sdm230"}, {Connection: "a", Device: "sdm230"}}},
+			"Device name sdm230 is used more than once"},
+	}
+	for _, tst := range tests {
+		_, err := NewPoller(tst.cfg)
+		if err == nil || err.Error() != tst.err {
+			t.Fatalf("Incorrect error. Got %v expected %s", err, tst.err)
+		}
+	}
+}
+
+func TestPoller(t *testing.T) {
+	client := NewFakeClient()
+	client.Input[0x0a] = 1234
+	defer func(orig func(ConnectionConfig) (*modbusConnection, error)) { dial = orig }(dial)
+	var slaves []byte
+	dial = func(cc ConnectionConfig) (*modbusConnection, error) {
+		if cc.Name == "offline" {
+			return &modbusConnection{client: failingClient{NewFakeClient()}, setSlave: func(byte) {},
+				close: func() error { return nil }}, fmt.Errorf("connection refused")
+		}
+		return &modbusConnection{client: client, setSlave: func(id byte) { slaves = append(slaves, id) },
+			close: func() error { return nil }}, nil
+	}
+
+	pl, err := NewPoller(PollerConfig{
+		Connections: []ConnectionConfig{{Name: "inverter", TCP: "localhost:502"}, {Name: "offline", TCP: "localhost:503"}},
+		Devices: []PollDevice{{Connection: "inverter", Device: "solaxx1hybrid", SlaveID: 1, Interval: 0.01},
+			{Name: "meter", Connection: "offline", Device: "sdm230", Interval: 0.01}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl.cfg.Devices[1].SlaveID != 1 {
+		t.Fatalf("Incorrect default slave ID. Got %d expected 1", pl.cfg.Devices[1].SlaveID)
+	}
+	ms := &memorySink{}
+	pl.sinks = Sinks{ms}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err = pl.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !ms.opened || !ms.closed {
+		t.Fatalf("Sink was not opened and closed")
+	}
+	if len(ms.readings) < 2 || ms.readings[0].Values[30011].Ieee32 != 1234 {
+		t.Fatalf("Incorrect readings written. Got %d readings", len(ms.readings))
+	}
+	if len(slaves) == 0 || slaves[0] != 1 {
+		t.Fatalf("Slave ID was not set")
+	}
+
+	status := pl.Health()
+	if status.Healthy || len(status.Devices) != 2 {
+		t.Fatalf("Incorrect health status %+v", status)
+	}
+	meter, solax := status.Devices[0], status.Devices[1]
+	if meter.Name != "meter" || meter.Healthy || meter.Errors == 0 || meter.LastError == "" || meter.LastPoll != nil {
+		t.Fatalf("Incorrect health for meter %+v", meter)
+	}
+	if solax.Name != "solaxx1hybrid" || !solax.Healthy || solax.Polls < 2 || solax.Errors != 0 {
+		t.Fatalf("Incorrect health for solaxx1hybrid %+v", solax)
+	}
+
+	rec := httptest.NewRecorder()
+	pl.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Incorrect status code. Got %d expected %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if body := rec.Body.String(); strings.Count(body, "last_poll") != 1 {
+		t.Fatalf("last_poll should only be included for the device polled: %s", body)
+	}
+}
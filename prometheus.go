@@ -0,0 +1,269 @@
+package modbusdev
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultNamespace = "modbusdev"
+
+// promUnits Suffixes added to metric names for each of the units used by the devices. Energy
+// registers are exported as counters.
+var promUnits = map[string]struct {
+	suffix  string
+	counter bool
+}{
+	"A":       {"amperes", false},
+	"Amps":    {"amperes", false},
+	"C":       {"celsius", false},
+	"Degrees": {"degrees", false},
+	"Hz":      {"hertz", false},
+	"V":       {"volts", false},
+	"VA":      {"volt_amperes", false},
+	"VAr":     {"volt_amperes_reactive", false},
+	"W":       {"watts", false},
+	"kW":      {"kilowatts", false},
+	"kWh":     {"kilowatt_hours", true},
+	"kVArh":   {"kilovolt_ampere_reactive_hours", true},
+	"ms":      {"milliseconds", false},
+	"%":       {"percent", false},
+}
+
+var promInvalid = regexp.MustCompile("[^a-zA-Z0-9_]+")
+
+// PrometheusExporter An http.Handler that exposes register values as Prometheus metrics, along
+// with the duration and errors of each poll.
+//
+// Values come from the latest poll, which can be supplied either by using the exporter as a Sink
+// or by calling Poll, or by adding Readers which are read on every scrape.
+type PrometheusExporter struct {
+	// Namespace The prefix for all metric names, modbusdev if not set.
+	Namespace string
+
+	mu      sync.Mutex
+	scrape  sync.Mutex
+	readers []*Reader
+	latest  map[string]Readings
+	polls   map[string]*promPoll
+}
+
+type promPoll struct {
+	device   string
+	slaveID  byte
+	count    uint64
+	errors   uint64
+	duration time.Duration
+	last     time.Time
+}
+
+type promSample struct {
+	labels string
+	value  float64
+}
+
+type promMetric struct {
+	help    string
+	kind    string
+	samples []promSample
+}
+
+// NewPrometheusExporter Return a new PrometheusExporter with no readings.
+func NewPrometheusExporter() *PrometheusExporter {
+	return &PrometheusExporter{}
+}
+
+// AddReader Add a Reader that will be read each time the metrics are scraped.
+func (pe *PrometheusExporter) AddReader(rdr *Reader) {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	pe.readers = append(pe.readers, rdr)
+}
+
+// Poll Read the registers using the Reader, recording the duration and any error, and keep the
+// results for the next scrape. The readings are returned so they can be passed to other sinks.
+func (pe *PrometheusExporter) Poll(rdr *Reader) (Readings, error) {
+	start := time.Now()
+	readings, err := rdr.Readings(true)
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	pe.record(rdr.device, rdr.slaveID, time.Since(start), err)
+	if err == nil {
+		pe.store(readings)
+	}
+	return readings, err
+}
+
+// Open Part of the Sink interface, nothing is required.
+func (pe *PrometheusExporter) Open() error {
+	return nil
+}
+
+// Write Keep the readings to be exported on the next scrape.
+func (pe *PrometheusExporter) Write(readings Readings) error {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	pe.store(readings)
+	return nil
+}
+
+// Close Part of the Sink interface, nothing is required.
+func (pe *PrometheusExporter) Close() error {
+	return nil
+}
+
+// ServeHTTP Read any Readers that have been added and return the metrics in the Prometheus text
+// format.
+func (pe *PrometheusExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Readers are not safe for concurrent use, so only one scrape reads them at a time.
+	pe.scrape.Lock()
+	pe.mu.Lock()
+	readers := append([]*Reader(nil), pe.readers...)
+	pe.mu.Unlock()
+	for _, rdr := range readers {
+		pe.Poll(rdr)
+	}
+	pe.scrape.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(pe.metrics())
+}
+
+func (pe *PrometheusExporter) key(device string, slaveID byte) string {
+	return fmt.Sprintf("%s/%d", device, slaveID)
+}
+
+func (pe *PrometheusExporter) store(readings Readings) {
+	if pe.latest == nil {
+		pe.latest = make(map[string]Readings)
+	}
+	pe.latest[pe.key(readings.Device, readings.SlaveID)] = readings
+}
+
+func (pe *PrometheusExporter) record(device string, slaveID byte, duration time.Duration, err error) {
+	if pe.polls == nil {
+		pe.polls = make(map[string]*promPoll)
+	}
+	key := pe.key(device, slaveID)
+	poll, ck := pe.polls[key]
+	if !ck {
+		poll = &promPoll{device: device, slaveID: slaveID}
+		pe.polls[key] = poll
+	}
+	poll.count++
+	poll.duration = duration
+	if err != nil {
+		poll.errors++
+		return
+	}
+	poll.last = time.Now()
+}
+
+// metrics Return all metrics in the Prometheus text format.
+func (pe *PrometheusExporter) metrics() []byte {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	namespace := pe.Namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	metrics := make(map[string]*promMetric)
+	add := func(name, help, kind, labels string, value float64) {
+		metric, ck := metrics[name]
+		if !ck {
+			metric = &promMetric{help: help, kind: kind}
+			metrics[name] = metric
+		}
+		metric.samples = append(metric.samples, promSample{labels, value})
+	}
+
+	for _, readings := range pe.latest {
+		for code := range readings.Values {
+			reg, ck := readings.Registers[code]
+			if !ck {
+				continue
+			}
+			name, counter := promName(namespace, readings.Device, readings.Name(code), reg)
+			kind := "gauge"
+			if counter {
+				kind = "counter"
+			}
+			labels := promLabels("device", readings.Device, "slave", strconv.Itoa(int(readings.SlaveID)),
+				"code", strconv.Itoa(code), "description", reg.Description, "units", reg.Units)
+			add(name, promHelp(reg), kind, labels, readings.FactoredValue(code))
+		}
+	}
+	for _, poll := range pe.polls {
+		labels := promLabels("device", poll.device, "slave", strconv.Itoa(int(poll.slaveID)))
+		add(namespace+"_poll_duration_seconds", "Time taken by the most recent poll.", "gauge",
+			labels, poll.duration.Seconds())
+		add(namespace+"_polls_total", "Number of polls attempted.", "counter", labels, float64(poll.count))
+		add(namespace+"_poll_errors_total", "Number of polls that failed.", "counter", labels, float64(poll.errors))
+		if !poll.last.IsZero() {
+			add(namespace+"_last_poll_timestamp_seconds", "Time of the most recent successful poll.",
+				"gauge", labels, float64(poll.last.UnixNano())/1e9)
+		}
+	}
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		metric := metrics[name]
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, metric.help, name, metric.kind)
+		sort.Slice(metric.samples, func(i, j int) bool { return metric.samples[i].labels < metric.samples[j].labels })
+		for _, sample := range metric.samples {
+			fmt.Fprintf(&buf, "%s{%s} %s\n", name, sample.labels,
+				strconv.FormatFloat(sample.value, 'g', -1, 64))
+		}
+	}
+	return buf.Bytes()
+}
+
+// promName Return the metric name for the register and whether it should be a counter. Names are
+// made from the namespace, device, register name and units, e.g.
+// modbusdev_sdm230_active_power_watts.
+func promName(namespace, device, name string, reg Register) (string, bool) {
+	if name == "" {
+		name = reg.Description
+	}
+	parts := []string{namespace, device, name}
+	unit, ck := promUnits[reg.Units]
+	if ck && !strings.HasSuffix(strings.ToLower(name), unit.suffix) {
+		parts = append(parts, unit.suffix)
+	}
+	if unit.counter {
+		parts = append(parts, "total")
+	}
+	name = strings.ToLower(promInvalid.ReplaceAllString(strings.Join(parts, "_"), "_"))
+	return strings.Trim(name, "_"), unit.counter
+}
+
+func promHelp(reg Register) string {
+	help := reg.Description
+	if reg.Units != "" {
+		help += " (" + reg.Units + ")"
+	}
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+// promLabels Return the label pairs formatted for the text format.
+func promLabels(pairs ...string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	labels := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, pairs[i], escaper.Replace(pairs[i+1])))
+	}
+	return strings.Join(labels, ",")
+}
@@ -0,0 +1,95 @@
+package modbusdev
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingClient A FakeClient where reading input registers always fails.
+type failingClient struct {
+	*FakeClient
+}
+
+func (fc failingClient) ReadInputRegisters(address, quantity uint16) ([]byte, error) {
+	return nil, fmt.Errorf("timeout")
+}
+
+func TestPromName(t *testing.T) {
+	tests := []struct {
+		reg      Register
+		name     string
+		expected string
+		counter  bool
+	}{
+		{sdm230[30013], "active_power", "modbusdev_sdm230_active_power_watts", false},
+		{sdm230[30073], "import_active_energy", "modbusdev_sdm230_import_active_energy_kilowatt_hours_total", true},
+		{sdm230[30031], "power_factor", "modbusdev_sdm230_power_factor", false},
+		{Register{"Battery Volts", "V", 0, "u16", 1}, "battery_volts", "modbusdev_sdm230_battery_volts", false},
+	}
+	for _, tst := range tests {
+		name, counter := promName("modbusdev", "sdm230", tst.name, tst.reg)
+		if name != tst.expected || counter != tst.counter {
+			t.Fatalf("Incorrect value. Got %s, %v expected %s, %v", name, counter, tst.expected, tst.counter)
+		}
+	}
+}
+
+func TestPrometheusExporter(t *testing.T) {
+	client := NewFakeClient()
+	client.Input[0x0a] = 1234
+	rdr, err := NewReader(client, "solaxx1hybrid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pe := NewPrometheusExporter()
+	pe.AddReader(&rdr)
+
+	failing, err := NewReader(failingClient{NewFakeClient()}, "sdm230")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pe.Poll(&failing); err == nil {
+		t.Fatal("Expected error from failing reader")
+	}
+
+	rec := httptest.NewRecorder()
+	pe.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, expected := range []string{
+		"# TYPE modbusdev_solaxx1hybrid_pv1_power_watts gauge\n",
+		`modbusdev_solaxx1hybrid_pv1_power_watts{device="solaxx1hybrid",slave="0",code="30011",description="PV1 Power",units="W"} 1234` + "\n",
+		`modbusdev_polls_total{device="solaxx1hybrid",slave="0"} 1` + "\n",
+		`modbusdev_poll_errors_total{device="sdm230",slave="0"} 1` + "\n",
+		`modbusdev_poll_errors_total{device="solaxx1hybrid",slave="0"} 0` + "\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("Metrics missing %s\n%s", expected, body)
+		}
+	}
+	if strings.Contains(body, "modbusdev_sdm230_active_power") {
+		t.Fatal("Metrics included values from failed poll")
+	}
+	if rec.Header().Get("Content-Type") != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Fatalf("Incorrect content type %s", rec.Header().Get("Content-Type"))
+	}
+
+	pe.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(string(pe.metrics()), `modbusdev_polls_total{device="solaxx1hybrid",slave="0"} 2`) {
+		t.Fatal("Reader was not read on each scrape")
+	}
+}
+
+func TestPrometheusSink(t *testing.T) {
+	pe := NewPrometheusExporter()
+	pe.Namespace = "site"
+	readings := influxReadings(t)
+	if err := pe.Write(readings); err != nil {
+		t.Fatal(err)
+	}
+	body := string(pe.metrics())
+	if !strings.Contains(body, `site_solaxx1hybrid_pv1_power_watts{device="solaxx1hybrid",slave="3",`) {
+		t.Fatalf("Metrics missing written readings\n%s", body)
+	}
+}
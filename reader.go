@@ -3,34 +3,36 @@ package modbusdev
 import (
 	"fmt"
 	"log"
-	"sort"
+	"strings"
+	"time"
 
 	"github.com/goburrow/modbus"
 )
 
-const (
-	ieeeFmt = "%12.2f"
-	numFmt  = "%15d"
-	baseFmt = "  %5d: %-40s "
-)
-
 // Reader A reader structure allows us to tie a client to a device register map
 type Reader struct {
-	client    modbus.Client
-	registers map[int]Register
-	holding   registerCache
-	input     registerCache
+	client     modbus.Client
+	device     string
+	slaveID    byte
+	registers  map[int]Register
+	names      map[int]string
+	composites map[int]Composite
+	holding    registerCache
+	input      registerCache
 }
 
 // NewReader Return a configured Reader with the correct register mappings.
 // Device names are converted to lower case for matching, so case provided is irrelevant.
 func NewReader(client modbus.Client, device string) (rdr Reader, err error) {
 	rdr.client = client
+	rdr.device = strings.ToLower(device)
 	regs, err := RegistersByName(device)
 	if err != nil {
 		return
 	}
 	rdr.registers = regs
+	rdr.names = registerNames(regs, namesByName(device))
+	rdr.composites = compositesByName(device)
 	rdr.input.init()
 	rdr.holding.init()
 
@@ -46,6 +48,12 @@ func NewReader(client modbus.Client, device string) (rdr Reader, err error) {
 	return
 }
 
+// SetSlaveID Set the slave ID of the device. This is only used to identify the device in the
+// Readings, it does not change the client configuration.
+func (rdr *Reader) SetSlaveID(id byte) {
+	rdr.slaveID = id
+}
+
 // ReadRegister Read the register specified by the code. This always causes the device to be
 // queried.
 func (rdr *Reader) ReadRegister(code int, factored bool) (val Value, err error) {
@@ -65,6 +73,9 @@ func (rdr *Reader) ReadRegister(code int, factored bool) (val Value, err error)
 	if err != nil {
 		return val, err
 	}
+	if len(results) < int(nRqd)*2 {
+		return val, fmt.Errorf("Short response reading register %d, %d bytes vs expected %d", code, len(results), nRqd*2)
+	}
 	val.FormatBytes(reg.Format, results)
 	if factored {
 		reg.applyFactor(&val)
@@ -72,6 +83,20 @@ func (rdr *Reader) ReadRegister(code int, factored bool) (val Value, err error)
 	return val, nil
 }
 
+// ReadByName Read the register with the given name. This always causes the device to be queried.
+func (rdr *Reader) ReadByName(name string, factored bool) (val Value, err error) {
+	code, err := rdr.Code(name)
+	if err != nil {
+		return
+	}
+	return rdr.ReadRegister(code, factored)
+}
+
+// Code Return the code of the register or composite value with the given name.
+func (rdr *Reader) Code(name string) (int, error) {
+	return codeByName(rdr.names, rdr.composites, name)
+}
+
 func min(a, b uint16) uint16 {
 	if a > b {
 		return b
@@ -92,6 +117,10 @@ func (rdr *Reader) Read() error {
 			if err != nil {
 				return err
 			}
+			if len(results) < int(toRead)*2 {
+				return fmt.Errorf("Short response reading input registers from %d, %d bytes vs expected %d",
+					rdr.input.start+regsRead, len(results), toRead*2)
+			}
 
 			rdr.input.updateBytes(regsRead, results)
 			regsRead += toRead
@@ -109,6 +138,10 @@ func (rdr *Reader) Read() error {
 			if err != nil {
 				return err
 			}
+			if len(results) < int(toRead)*2 {
+				return fmt.Errorf("Short response reading holding registers from %d, %d bytes vs expected %d",
+					rdr.holding.start+regsRead, len(results), toRead*2)
+			}
 			rdr.holding.updateBytes(regsRead, results)
 			regsRead += toRead
 			if regsRead >= rdr.holding.qty {
@@ -132,97 +165,102 @@ func (rdr *Reader) Units(code int) string {
 	return reg.Units
 }
 
-// Get Return the data stored following a Read() call.
+// Get Return the data stored following a Read() call. Composite codes return the formatted
+// value in Text.
 func (rdr *Reader) Get(code int, factored bool) (rValue Value, err error) {
+	if comp, ck := rdr.composites[code]; ck {
+		return rdr.composite(comp)
+	}
 	reg, ck := rdr.registers[code]
 	if !ck {
 		err = fmt.Errorf("Code %d was not registered", code)
 		return
 	}
 
+	rValue = rdr.cached(code, reg)
+	if factored {
+		reg.applyFactor(&rValue)
+	}
+	return
+}
+
+// GetByName Return the data stored following a Read() call for the register with the given name.
+func (rdr *Reader) GetByName(name string, factored bool) (rValue Value, err error) {
+	code, err := rdr.Code(name)
+	if err != nil {
+		return
+	}
+	return rdr.Get(code, factored)
+}
+
+// cached Return the value of the register from the data obtained by the last Read() call.
+func (rdr *Reader) cached(code int, reg Register) (val Value) {
 	switch getRegisterType(code) {
 	case 3:
-		rValue = rdr.input.getValue(reg)
+		val = rdr.input.getValue(reg)
 	case 4:
-		rValue = rdr.holding.getValue(reg)
+		val = rdr.holding.getValue(reg)
 	}
-	if factored {
-		reg.applyFactor(&rValue)
+	return
+}
+
+func (rdr *Reader) composite(comp Composite) (val Value, err error) {
+	vals := make([]uint16, len(comp.Codes))
+	for i, code := range comp.Codes {
+		reg, ck := rdr.registers[code]
+		if !ck {
+			return val, fmt.Errorf("Code %d used by %s was not registered", code, comp.Description)
+		}
+		vals[i] = rdr.cached(code, reg).Unsigned16
 	}
+	val.Text, err = comp.format(vals)
 	return
 }
 
 // Map Return a map object of the registers. If getting a register returns a value it is
 // simply omitted from the map.
 func (rdr *Reader) Map(factored bool) map[int]Value {
-	mapValues := make(map[int]Value)
 	if err := rdr.Read(); err != nil {
 		log.Printf("Error reading values: %s", err)
-		return mapValues
+		return make(map[int]Value)
 	}
-
-	for code, reg := range rdr.registers {
-		var val Value
-		switch getRegisterType(code) {
-		case 3:
-			val = rdr.input.getValue(reg)
-		case 4:
-			val = rdr.holding.getValue(reg)
-		}
-		if factored {
-			reg.applyFactor(&val)
-		}
-		//		log.Printf("reg: %s => %f", reg.description, val.Ieee32)
-		mapValues[code] = val
-	}
-	return mapValues
+	return rdr.values(factored)
 }
 
-// Dump Query all defined registers and print the results to stdout.
-func (rdr *Reader) Dump(factored bool) {
-	if err := rdr.Read(); err != nil {
-		fmt.Printf("Unable to read register data from device.\n%s\n", err)
+// Readings Read the registers and return the results, along with details of the registers, in a
+// form suitable for passing to a Sink.
+func (rdr *Reader) Readings(factored bool) (readings Readings, err error) {
+	if err = rdr.Read(); err != nil {
 		return
 	}
+	readings.Device = rdr.device
+	readings.SlaveID = rdr.slaveID
+	readings.Time = time.Now()
+	readings.Factored = factored
+	readings.Registers = rdr.registers
+	readings.Composites = rdr.composites
+	readings.Names = rdr.names
+	readings.Values = rdr.values(factored)
+	return
+}
 
-	var keys []int
-	for k := range rdr.registers {
-		keys = append(keys, k)
-	}
-	sort.Ints(keys)
-
-	for _, code := range keys {
-		reg := rdr.registers[code]
-
-		var val Value
-		switch getRegisterType(code) {
-		case 3:
-			val = rdr.input.getValue(reg)
-		case 4:
-			val = rdr.holding.getValue(reg)
-		}
-
+// values Return a map of the values for all registers from the last Read() call.
+func (rdr *Reader) values(factored bool) map[int]Value {
+	mapValues := make(map[int]Value)
+	for code, reg := range rdr.registers {
+		val := rdr.cached(code, reg)
 		if factored {
 			reg.applyFactor(&val)
-			fmt.Printf(baseFmt+ieeeFmt+" %s\n", code, reg.Description, val.Ieee32, reg.Units)
-			continue
 		}
-
-		switch reg.Format {
-		case "u16":
-			fmt.Printf(baseFmt+numFmt+" %s\n", code, reg.Description, val.Unsigned16, reg.Units)
-		case "s16":
-			fmt.Printf(baseFmt+numFmt+"%s\n", code, reg.Description, val.Signed16, reg.Units)
-		case "u32":
-			fmt.Printf(baseFmt+numFmt+"%s\n", code, reg.Description, val.Unsigned32, reg.Units)
-		case "s32":
-			fmt.Printf(baseFmt+numFmt+"%s\n", code, reg.Description, val.Signed32, reg.Units)
-		case "ieee32":
-			fmt.Printf(baseFmt+ieeeFmt+"%s\n", code, reg.Description, val.Ieee32, reg.Units)
-		case "coil":
-			fmt.Printf(baseFmt+"%t\n", code, reg.Description, val.Coil)
+		//		log.Printf("reg: %s => %f", reg.description, val.Ieee32)
+		mapValues[code] = val
+	}
+	for code, comp := range rdr.composites {
+		if val, err := rdr.composite(comp); err == nil {
+			mapValues[code] = val
 		}
 	}
+	return mapValues
 }
 
 // ScanHolding Given a start and stop register, scan the holding registers. Added as a
@@ -234,6 +272,10 @@ func (rdr *Reader) ScanHolding(start, stop uint16) {
 		fmt.Printf("Unable to read registers %d to %d\n%s\n", start, stop, err)
 		return
 	}
+	if len(results) < int(qty)*2 {
+		fmt.Printf("Short response reading registers %d to %d, %d bytes vs expected %d\n", start, stop, len(results), qty*2)
+		return
+	}
 	for n := uint16(0); n < qty; n++ {
 		reg := start + n
 		val := uint16(results[n*2])<<8 + uint16(results[n*2+1])
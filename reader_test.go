@@ -0,0 +1,117 @@
+package modbusdev
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestReaderRead(t *testing.T) {
+	client := NewFakeClient()
+	client.SetInput(0x00, 2405, 0xFF9C)
+	client.SetInput(0x1D, 0x0001, 0x86A0)
+	rdr, err := NewReader(client, "solaxx1hybrid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rdr.Read(); err != nil {
+		t.Fatal(err)
+	}
+	reqs := client.Requests()
+	if len(reqs) != 1 || reqs[0].Function != modbus.FuncCodeReadInputRegisters || reqs[0].Address != 0 {
+		t.Fatalf("Incorrect requests made %+v", reqs)
+	}
+
+	tests := []struct {
+		code     int
+		factored bool
+		value    float64
+	}{
+		{30001, true, 240.5},
+		{30002, true, -10},
+		{30030, false, 100000},
+	}
+	for _, tst := range tests {
+		val, err := rdr.Get(tst.code, tst.factored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := rdr.registers[tst.code].rawValue(val)
+		if tst.factored {
+			got = val.Ieee32
+		}
+		if got != tst.value {
+			t.Fatalf("Incorrect value for %d. Got %f expected %f", tst.code, got, tst.value)
+		}
+	}
+}
+
+func TestReaderReadHolding(t *testing.T) {
+	client := NewFakeClient()
+	client.SetHolding(0xB6, 3000)
+	rdr, err := NewReader(client, "solaxx1hybridex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := rdr.Map(true)
+	if values[40183].Ieee32 != 3000 {
+		t.Fatalf("Incorrect value. Got %f expected 3000", values[40183].Ieee32)
+	}
+	var input, holding int
+	for _, req := range client.Requests() {
+		switch req.Function {
+		case modbus.FuncCodeReadInputRegisters:
+			input++
+		case modbus.FuncCodeReadHoldingRegisters:
+			holding++
+			if req.Quantity > 125 {
+				t.Fatalf("Too many registers requested. Got %d", req.Quantity)
+			}
+		}
+	}
+	if input == 0 || holding < 2 {
+		t.Fatalf("Incorrect requests made. Got %d input and %d holding", input, holding)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	client := NewFakeClient()
+	client.SetInput(0x0a, 1234)
+	rdr, err := NewReader(client, "solaxx1hybrid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.AddFault(FakeFault{Exception: modbus.ExceptionCodeServerDeviceBusy, Count: 1})
+	if values := rdr.Map(true); len(values) != 0 {
+		t.Fatalf("Incorrect value. Got %d values expected 0", len(values))
+	}
+	if values := rdr.Map(true); values[30011].Ieee32 != 1234 {
+		t.Fatalf("Incorrect value. Got %f expected 1234", values[30011].Ieee32)
+	}
+
+	client.AddFault(FakeFault{Timeout: true})
+	var buf strings.Builder
+	err = rdr.Dump(&buf, "table")
+	if err == nil || err.Error() != "Unable to read register data from device: modbus: i/o timeout" {
+		t.Fatalf("Incorrect error. Got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("Output written after an error: %s", buf.String())
+	}
+	client.ClearFaults()
+
+	client.AddFault(FakeFault{Short: true, Count: 1})
+	_, err = rdr.ReadRegister(30011, true)
+	if err == nil || err.Error() != "Short response reading register 30011, 1 bytes vs expected 2" {
+		t.Fatalf("Incorrect error. Got %v", err)
+	}
+	client.AddFault(FakeFault{Short: true, Count: 1})
+	if err = rdr.Read(); err == nil || !strings.HasPrefix(err.Error(), "Short response reading input registers") {
+		t.Fatalf("Incorrect error. Got %v", err)
+	}
+	if err = rdr.Read(); err != nil {
+		t.Fatal(err)
+	}
+}
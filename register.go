@@ -1,5 +1,11 @@
 package modbusdev
 
+import (
+	"math"
+	"regexp"
+	"strings"
+)
+
 // Register Structure that contains details of the register value available.
 type Register struct {
 	Description string
@@ -9,6 +15,27 @@ type Register struct {
 	Factor      float64
 }
 
+var nameReplacer = regexp.MustCompile("[^a-z0-9]+")
+
+// name Return a name for the register derived from the description, e.g. pv1_power for
+// "PV1 Power". Used for registers that have not been given a name.
+func (r Register) name() string {
+	return strings.Trim(nameReplacer.ReplaceAllString(strings.ToLower(r.Description), "_"), "_")
+}
+
+// registerNames Return the names of all the registers, using the names given where available.
+func registerNames(registers map[int]Register, names map[int]string) map[int]string {
+	all := make(map[int]string, len(registers))
+	for code, reg := range registers {
+		if name, ck := names[code]; ck {
+			all[code] = name
+		} else {
+			all[code] = reg.name()
+		}
+	}
+	return all
+}
+
 type registerCache struct {
 	start        uint16
 	qty          uint16
@@ -44,6 +71,57 @@ func (r Register) applyFactor(val *Value) {
 	}
 }
 
+// rawValue Return the unfactored value for the register as a float.
+func (r Register) rawValue(val Value) float64 {
+	switch r.Format {
+	case "u16":
+		return float64(val.Unsigned16)
+	case "s16":
+		return float64(val.Signed16)
+	case "u32":
+		return float64(val.Unsigned32)
+	case "s32":
+		return float64(val.Signed32)
+	case "coil":
+		if val.Coil {
+			return 1
+		}
+		return 0
+	}
+	return val.Ieee32
+}
+
+// writable Return true if values can be written to the register using WriteFactored. Coils and
+// ieee32 registers cannot be written that way.
+func (r Register) writable() bool {
+	return r.Format != "coil" && r.Format != "ieee32"
+}
+
+// limits Return the smallest and largest values, after applying the factor, that can be stored
+// in the register.
+func (r Register) limits() (float64, float64) {
+	factor := r.Factor
+	if factor == 0 {
+		factor = 1
+	}
+	var lower, upper float64
+	switch r.Format {
+	case "u16":
+		upper = math.MaxUint16
+	case "s16":
+		lower, upper = math.MinInt16, math.MaxInt16
+	case "u32":
+		upper = math.MaxUint32
+	case "s32":
+		lower, upper = math.MinInt32, math.MaxInt32
+	case "coil":
+		upper = 1
+	default:
+		return -math.MaxFloat32, math.MaxFloat32
+	}
+	return lower * factor, upper * factor
+}
+
 func (rc *registerCache) init() {
 	rc.registerData = make(map[int]byte)
 	rc.start = 65535
@@ -66,7 +144,7 @@ func (rc *registerCache) updateBytes(offset uint16, newBytes []byte) {
 }
 
 func (rc *registerCache) getValue(reg Register) Value {
-	idx := int(reg.Register-rc.start) * 2
+	idx := int(reg.Register) * 2
 	sz := int(reg.registersRqd() * 2)
 	rawBytes := make([]byte, sz)
 	for i := 0; i < sz; i++ {
@@ -42,3 +42,14 @@ func TestRegisterCache(t *testing.T) {
 	}
 
 }
+
+func TestRegisterCacheValues(t *testing.T) {
+	r := Register{"Test", "", 0x19, "u16", 1}
+	rc := registerCache{}
+	rc.init()
+	rc.update(r)
+	rc.updateBytes(0, []byte{0x01, 0x02})
+	if val := rc.getValue(r); val.Unsigned16 != 0x0102 {
+		t.Fatalf("Incorrect cached value, %04X vs expected 0102", val.Unsigned16)
+	}
+}
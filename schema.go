@@ -0,0 +1,209 @@
+package modbusdev
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// schemaColumn Details of a column that will hold the values for a field.
+type schemaColumn struct {
+	Name    string
+	Type    string
+	Comment string
+}
+
+// registerSQLType Return a suitable SQL type for the values that will be stored for a register.
+// Factored values are always stored as floating point.
+func registerSQLType(reg Register) string {
+	if reg.Factor != 1 {
+		return "DOUBLE PRECISION"
+	}
+	switch reg.Format {
+	case "u16", "s16":
+		return "INTEGER"
+	case "u32", "s32":
+		return "BIGINT"
+	case "coil":
+		return "BOOLEAN"
+	}
+	return "DOUBLE PRECISION"
+}
+
+// fieldSQLType Return the SQL type for the values that will be stored for the field.
+func fieldSQLType(fld DatabaseField, reg Register) string {
+	switch strings.ToLower(fld.Type) {
+	case "int":
+		return "BIGINT"
+	case "float":
+		return "DOUBLE PRECISION"
+	case "bool":
+		return "BOOLEAN"
+	case "string", "enum":
+		return "TEXT"
+	}
+	if fld.Scale != 0 || fld.Offset != 0 {
+		return "DOUBLE PRECISION"
+	}
+	if fld.Raw {
+		reg.Factor = 1
+	}
+	return registerSQLType(reg)
+}
+
+// registerComment Return a comment for a column, built from the description and units.
+func registerComment(description, units string) string {
+	if units == "" {
+		return description
+	}
+	return fmt.Sprintf("%s (%s)", description, units)
+}
+
+// deviceFields Return a field for every register and composite of the device, named using the
+// register names and sorted by code.
+func deviceFields(device string) ([]DatabaseField, error) {
+	regs, err := RegistersByName(device)
+	if err != nil {
+		return nil, err
+	}
+	var fields []DatabaseField
+	for code, name := range registerNames(regs, namesByName(device)) {
+		fields = append(fields, DatabaseField{Name: name, Code: code})
+	}
+	for code, comp := range compositesByName(device) {
+		fields = append(fields, DatabaseField{Name: comp.Name, Code: code})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Code < fields[j].Code })
+	return fields, nil
+}
+
+// schemaColumns Return the columns required to store the fields for the device.
+func schemaColumns(device string, fields []DatabaseField) ([]schemaColumn, error) {
+	regs, err := RegistersByName(device)
+	if err != nil {
+		return nil, err
+	}
+	composites := compositesByName(device)
+	columns := make([]schemaColumn, len(fields))
+	for i, fld := range fields {
+		if reg, ck := regs[fld.Code]; ck {
+			columns[i] = schemaColumn{fld.Name, fieldSQLType(fld, reg), registerComment(reg.Description, reg.Units)}
+		} else if comp, ck := composites[fld.Code]; ck {
+			columns[i] = schemaColumn{fld.Name, "TEXT", comp.Description}
+		} else {
+			return nil, fmt.Errorf("Code %d [%s] is not available for device %s", fld.Code, fld.Name, device)
+		}
+	}
+	return columns, nil
+}
+
+func createTableSQL(table string, columns []schemaColumn) string {
+	defs := []string{"time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()"}
+	for _, col := range columns {
+		defs = append(defs, col.Name+" "+col.Type)
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(defs, ", "))
+}
+
+func addColumnSQL(table string, col schemaColumn) string {
+	return fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, col.Name, col.Type)
+}
+
+// tableNames Split a table name, which may be qualified by a schema, into the schema and table
+// names as they are stored by postgresql. Quoted names are unquoted and other names are folded to
+// lower case. The schema is empty if not given.
+func tableNames(table string) (string, string) {
+	var parts []string
+	var part strings.Builder
+	quoted := false
+	for i := 0; i < len(table); i++ {
+		switch ch := table[i]; {
+		case ch == '"' && quoted && i+1 < len(table) && table[i+1] == '"':
+			part.WriteByte('"')
+			i++
+		case ch == '"':
+			quoted = !quoted
+		case ch == '.' && !quoted:
+			parts = append(parts, part.String())
+			part.Reset()
+		case quoted:
+			part.WriteByte(ch)
+		default:
+			part.WriteString(strings.ToLower(string(ch)))
+		}
+	}
+	parts = append(parts, part.String())
+	if len(parts) == 1 {
+		return "", parts[0]
+	}
+	return parts[len(parts)-2], parts[len(parts)-1]
+}
+
+// columnsSQL Return the query, and its arguments, listing the existing columns of the table.
+func columnsSQL(table string) (string, []interface{}) {
+	schema, name := tableNames(table)
+	if schema == "" {
+		return "SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1",
+			[]interface{}{name}
+	}
+	return "SELECT column_name FROM information_schema.columns WHERE table_schema = $1 AND table_name = $2",
+		[]interface{}{schema, name}
+}
+
+func commentSQL(table string, col schemaColumn) string {
+	return fmt.Sprintf("COMMENT ON COLUMN %s.%s IS '%s'", table, col.Name, strings.ReplaceAll(col.Comment, "'", "''"))
+}
+
+// migrateTable Create the table if it doesn't exist and add columns for any fields that are not
+// already present.
+func (dbC *DatabaseConnection) migrateTable() error {
+	if dbC.Device == "" {
+		return fmt.Errorf("Device must be specified to create table %s", dbC.Table)
+	}
+	if len(dbC.Fields) == 0 {
+		fields, err := deviceFields(dbC.Device)
+		if err != nil {
+			return err
+		}
+		dbC.Fields = fields
+	}
+	columns, err := schemaColumns(dbC.Device, dbC.Fields)
+	if err != nil {
+		return err
+	}
+	if _, err = dbC.db.Exec(createTableSQL(dbC.Table, columns)); err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool)
+	qry, args := columnsSQL(dbC.Table)
+	rows, err := dbC.db.Query(qry, args...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+
+	for _, col := range columns {
+		if !existing[strings.ToLower(col.Name)] {
+			if _, err = dbC.db.Exec(addColumnSQL(dbC.Table, col)); err != nil {
+				return err
+			}
+		}
+		if _, err = dbC.db.Exec(commentSQL(dbC.Table, col)); err != nil {
+			return err
+		}
+	}
+	if dbC.Query == "" {
+		dbC.Query = fmt.Sprintf("INSERT INTO %s (time%%s) VALUES ($1%%s)", dbC.Table)
+		dbC.timed = true
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package modbusdev
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSchemaColumns(t *testing.T) {
+	fields := []DatabaseField{{Name: "grid_voltage", Code: 30001}, {Name: "pv1", Code: 30011}, {Name: "energy", Code: 30082}}
+	columns, err := schemaColumns("solaxx1hybrid", fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []schemaColumn{
+		{"grid_voltage", "DOUBLE PRECISION", "Grid Voltage (V)"},
+		{"pv1", "INTEGER", "PV1 Power (W)"},
+		{"energy", "DOUBLE PRECISION", "Energy Total (kW)"},
+	}
+	for i, col := range columns {
+		if col != expected[i] {
+			t.Fatalf("Incorrect column. Got %+v expected %+v", col, expected[i])
+		}
+	}
+	regs, _ := RegistersByName("solaxx1hybrid")
+	qryData, err := fieldData(fields, regs, map[int]Value{30001: {Unsigned16: 2401}, 30011: {Unsigned16: 1500},
+		30082: {Unsigned32: 12345}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ck := qryData[0].(float64); !ck {
+		t.Fatalf("Incorrect value for DOUBLE PRECISION column. Got %T expected float64", qryData[0])
+	}
+	if qryData[1] != int64(1500) {
+		t.Fatalf("Incorrect value for INTEGER column. Got %v (%T) expected 1500", qryData[1], qryData[1])
+	}
+	if _, err := schemaColumns("solaxx1hybrid", []DatabaseField{{Name: "missing", Code: 39999}}); err == nil {
+		t.Fatal("Expected error for unknown code")
+	}
+
+	sql := createTableSQL("solax", columns[:2])
+	if sql != "CREATE TABLE IF NOT EXISTS solax (time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(), grid_voltage DOUBLE PRECISION, pv1 INTEGER)" {
+		t.Fatalf("Incorrect create statement: %s", sql)
+	}
+	sql = commentSQL("solax", schemaColumn{"temp", "INTEGER", "Inverter's Temp (C)"})
+	if sql != "COMMENT ON COLUMN solax.temp IS 'Inverter''s Temp (C)'" {
+		t.Fatalf("Incorrect comment statement: %s", sql)
+	}
+}
+
+func TestDeviceFields(t *testing.T) {
+	fields, err := deviceFields("solaxx1hybridex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	regs, _ := RegistersByName("solaxx1hybridex")
+	if len(fields) != len(regs)+len(compositesByName("solaxx1hybridex")) {
+		t.Fatalf("Incorrect number of fields, %d", len(fields))
+	}
+	if fields[0].Name != "grid_voltage" || fields[0].Code != 30001 {
+		t.Fatalf("Incorrect first field %+v", fields[0])
+	}
+}
+
+func TestTableNames(t *testing.T) {
+	tests := []struct {
+		table  string
+		schema string
+		name   string
+	}{
+		{"solax", "", "solax"},
+		{"Solax", "", "solax"},
+		{"energy.solax", "energy", "solax"},
+		{`"Energy"."SolaX"`, "Energy", "SolaX"},
+		{`energy."Sola.X"`, "energy", "Sola.X"},
+		{`db.energy.solax`, "energy", "solax"},
+	}
+	for _, tst := range tests {
+		if schema, name := tableNames(tst.table); schema != tst.schema || name != tst.name {
+			t.Fatalf("Incorrect value for %s. Got %s, %s expected %s, %s", tst.table, schema, name, tst.schema, tst.name)
+		}
+	}
+	qry, args := columnsSQL("energy.solax")
+	if !strings.Contains(qry, "table_schema = $1 AND table_name = $2") || len(args) != 2 || args[0] != "energy" || args[1] != "solax" {
+		t.Fatalf("Incorrect columns query %s %v", qry, args)
+	}
+	if sql := addColumnSQL("energy.solax", schemaColumn{"pv1", "INTEGER", ""}); sql != "ALTER TABLE energy.solax ADD COLUMN IF NOT EXISTS pv1 INTEGER" {
+		t.Fatalf("Incorrect add column statement: %s", sql)
+	}
+}
@@ -0,0 +1,399 @@
+package modbusdev
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultSimulatorAddress = "127.0.0.1:5020"
+
+// Modbus exception codes returned by the Simulator.
+const (
+	exceptionIllegalFunction = 0x01
+	exceptionIllegalAddress  = 0x02
+	exceptionIllegalValue    = 0x03
+	exceptionGatewayTarget   = 0x0B
+)
+
+// SimulatorConfig The configuration of a Simulator, usually loaded from a JSON file.
+//
+//	{
+//	    "Device": "solaxx1hybrid",
+//	    "Address": "127.0.0.1:5020",
+//	    "Snapshot": "solax.json",
+//	    "Values": {
+//	        "grid_voltage": {"Value": 240.5},
+//	        "pv1_power": {"Curve": "sine", "Min": 0, "Max": 3000, "Period": 86400},
+//	        "feed_in_energy": {"Curve": "increment", "Value": 1000, "Step": 0.001}
+//	    }
+//	}
+type SimulatorConfig struct {
+	Device string
+	// Address The address to listen on, 127.0.0.1:5020 if not set.
+	Address string
+	// SlaveID If set, requests for other slaves are answered with an exception.
+	SlaveID byte
+	// Snapshot A file of readings written by Dump in json or jsonl format, used as the initial
+	// raw values of the registers.
+	Snapshot string
+	// Values Values for registers, keyed by code or name. These override the snapshot.
+	Values map[string]SimulatedValue
+}
+
+// SimulatedValue How the value of a register is generated. All values have the factor applied.
+//
+//	static     Always Value, the default
+//	sine       Varies between Min and Max over Period seconds
+//	increment  Starts at Value and increases by Step every second
+//
+// Once a holding register has been written the written value is used instead.
+type SimulatedValue struct {
+	Curve  string
+	Value  float64
+	Min    float64
+	Max    float64
+	Period float64
+	Step   float64
+}
+
+// Simulator A Modbus TCP slave that serves the registers of a device, allowing software to be
+// tested without the hardware. Input and holding registers can be read and holding registers
+// written, using function codes 3, 4, 6 and 16. Registers not used by the device read as 0.
+type Simulator struct {
+	cfg SimulatorConfig
+
+	mu        sync.Mutex
+	registers map[int]Register
+	names     map[int]string
+	curves    map[int]SimulatedValue
+	input     map[uint16]uint16
+	holding   map[uint16]uint16
+	remap     map[uint16]uint16
+	started   time.Time
+	listener  net.Listener
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// NewSimulator Check the configuration and return a Simulator ready to be opened.
+func NewSimulator(cfg SimulatorConfig) (*Simulator, error) {
+	regs, err := RegistersByName(cfg.Device)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Address == "" {
+		cfg.Address = defaultSimulatorAddress
+	}
+	sim := &Simulator{cfg: cfg, registers: regs, names: registerNames(regs, namesByName(cfg.Device)), curves: make(map[int]SimulatedValue),
+		input: make(map[uint16]uint16), holding: make(map[uint16]uint16), remap: make(map[uint16]uint16)}
+	for code, address := range writeAddressesByName(cfg.Device) {
+		sim.remap[address] = regs[code].Register
+	}
+	if cfg.Snapshot != "" {
+		if err = sim.loadSnapshot(cfg.Snapshot); err != nil {
+			return nil, err
+		}
+	}
+	for name, sv := range cfg.Values {
+		code, err := codeByName(sim.names, nil, name)
+		if err != nil {
+			return nil, err
+		}
+		if _, ck := regs[code]; !ck {
+			return nil, fmt.Errorf("Code %d is not a register of %s", code, cfg.Device)
+		}
+		switch strings.ToLower(sv.Curve) {
+		case "", "static":
+			sim.setValue(code, sv.Value)
+		case "sine":
+			if sv.Period <= 0 {
+				return nil, fmt.Errorf("A sine curve for %s requires a Period", name)
+			}
+			sim.curves[code] = sv
+		case "increment":
+			sim.curves[code] = sv
+		default:
+			return nil, fmt.Errorf("Unknown curve '%s' for %s", sv.Curve, name)
+		}
+	}
+	return sim, nil
+}
+
+// loadSnapshot Set the raw values of the registers from a file written by Dump.
+func (sim *Simulator) loadSnapshot(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var values []ReadingValue
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &values)
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for err == nil {
+			var val ReadingValue
+			if err = dec.Decode(&val); err == nil {
+				values = append(values, val)
+			}
+		}
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to parse snapshot %s: %s", filename, err)
+	}
+	for _, val := range values {
+		if _, ck := sim.registers[val.Code]; ck && val.Raw != nil {
+			sim.setRaw(val.Code, *val.Raw)
+		}
+	}
+	return nil
+}
+
+// Set Set the value, with the factor applied, of a register given by code or name. Any curve
+// configured for the register is removed.
+func (sim *Simulator) Set(name string, value float64) error {
+	code, err := codeByName(sim.names, nil, name)
+	if err != nil {
+		return err
+	}
+	if _, ck := sim.registers[code]; !ck {
+		return fmt.Errorf("Code %d is not a register of %s", code, sim.cfg.Device)
+	}
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	delete(sim.curves, code)
+	sim.setValue(code, value)
+	return nil
+}
+
+// Open Start listening and serving requests.
+func (sim *Simulator) Open() error {
+	listener, err := net.Listen("tcp", sim.cfg.Address)
+	if err != nil {
+		return err
+	}
+	sim.mu.Lock()
+	sim.listener = listener
+	sim.conns = make(map[net.Conn]struct{})
+	sim.started = time.Now()
+	sim.mu.Unlock()
+
+	sim.wg.Add(1)
+	go func() {
+		defer sim.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// A connection accepted while closing is closed rather than served, as Close may
+			// already have closed the others and be waiting.
+			sim.mu.Lock()
+			if sim.listener == nil {
+				sim.mu.Unlock()
+				conn.Close()
+				return
+			}
+			sim.conns[conn] = struct{}{}
+			sim.wg.Add(1)
+			sim.mu.Unlock()
+			go sim.serve(conn)
+		}
+	}()
+	return nil
+}
+
+// Addr Return the address being listened on, useful when the port was given as 0.
+func (sim *Simulator) Addr() string {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	if sim.listener == nil {
+		return sim.cfg.Address
+	}
+	return sim.listener.Addr().String()
+}
+
+// Close Stop listening and close all connections.
+func (sim *Simulator) Close() error {
+	sim.mu.Lock()
+	if sim.listener == nil {
+		sim.mu.Unlock()
+		return nil
+	}
+	err := sim.listener.Close()
+	sim.listener = nil
+	for conn := range sim.conns {
+		conn.Close()
+	}
+	sim.mu.Unlock()
+	sim.wg.Wait()
+	return err
+}
+
+// serve Answer requests on the connection until it is closed.
+func (sim *Simulator) serve(conn net.Conn) {
+	defer sim.wg.Done()
+	defer func() {
+		sim.mu.Lock()
+		delete(sim.conns, conn)
+		sim.mu.Unlock()
+		conn.Close()
+	}()
+	header := make([]byte, 7)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		length := binary.BigEndian.Uint16(header[4:])
+		if binary.BigEndian.Uint16(header[2:]) != 0 || length < 2 || length > 254 {
+			log.Printf("Simulator received an invalid header from %s", conn.RemoteAddr())
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+		response := sim.handle(header[6], pdu)
+		frame := make([]byte, 7, 7+len(response))
+		copy(frame, header[:4])
+		binary.BigEndian.PutUint16(frame[4:], uint16(len(response)+1))
+		frame[6] = header[6]
+		if _, err := conn.Write(append(frame, response...)); err != nil {
+			return
+		}
+	}
+}
+
+// handle Return the response to a request.
+func (sim *Simulator) handle(slaveID byte, pdu []byte) []byte {
+	function := pdu[0]
+	if sim.cfg.SlaveID != 0 && slaveID != sim.cfg.SlaveID {
+		return []byte{function | 0x80, exceptionGatewayTarget}
+	}
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	switch function {
+	case 3, 4:
+		if len(pdu) != 5 {
+			return []byte{function | 0x80, exceptionIllegalValue}
+		}
+		address, qty := binary.BigEndian.Uint16(pdu[1:]), binary.BigEndian.Uint16(pdu[3:])
+		if qty == 0 || qty > 125 {
+			return []byte{function | 0x80, exceptionIllegalValue}
+		}
+		if int(address)+int(qty) > 65536 {
+			return []byte{function | 0x80, exceptionIllegalAddress}
+		}
+		sim.update(time.Now())
+		regs := sim.holding
+		if function == 4 {
+			regs = sim.input
+		}
+		response := make([]byte, 2+qty*2)
+		response[0], response[1] = function, byte(qty*2)
+		for n := uint16(0); n < qty; n++ {
+			binary.BigEndian.PutUint16(response[2+n*2:], regs[address+n])
+		}
+		return response
+	case 6:
+		if len(pdu) != 5 {
+			return []byte{function | 0x80, exceptionIllegalValue}
+		}
+		sim.write(binary.BigEndian.Uint16(pdu[1:]), pdu[3:5])
+		return pdu
+	case 16:
+		if len(pdu) < 6 {
+			return []byte{function | 0x80, exceptionIllegalValue}
+		}
+		address, qty := binary.BigEndian.Uint16(pdu[1:]), binary.BigEndian.Uint16(pdu[3:])
+		if qty == 0 || qty > 123 || int(pdu[5]) != int(qty)*2 || len(pdu) != 6+int(qty)*2 {
+			return []byte{function | 0x80, exceptionIllegalValue}
+		}
+		if int(address)+int(qty) > 65536 {
+			return []byte{function | 0x80, exceptionIllegalAddress}
+		}
+		sim.write(address, pdu[6:])
+		return pdu[:5]
+	}
+	return []byte{function | 0x80, exceptionIllegalFunction}
+}
+
+// write Store the values written to holding registers, starting at address. Any curves for the
+// registers written are removed.
+func (sim *Simulator) write(address uint16, values []byte) {
+	if readAddress, ck := sim.remap[address]; ck {
+		address = readAddress
+	}
+	qty := uint16(len(values) / 2)
+	for n := uint16(0); n < qty; n++ {
+		sim.holding[address+n] = binary.BigEndian.Uint16(values[n*2:])
+	}
+	for code := range sim.curves {
+		reg := sim.registers[code]
+		if getRegisterType(code) == 4 && reg.Register < address+qty && reg.maxRegister() > address {
+			delete(sim.curves, code)
+		}
+	}
+}
+
+// update Set the registers that have curves to their values at the given time.
+func (sim *Simulator) update(now time.Time) {
+	elapsed := now.Sub(sim.started).Seconds()
+	for code, sv := range sim.curves {
+		var value float64
+		switch strings.ToLower(sv.Curve) {
+		case "sine":
+			value = sv.Min + (sv.Max-sv.Min)*(1+math.Sin(2*math.Pi*elapsed/sv.Period))/2
+		case "increment":
+			value = sv.Value + sv.Step*elapsed
+		}
+		sim.setValue(code, value)
+	}
+}
+
+// setValue Store a value that has the factor applied.
+func (sim *Simulator) setValue(code int, value float64) {
+	reg := sim.registers[code]
+	if reg.Factor != 0 && reg.Format != "coil" {
+		value /= reg.Factor
+	}
+	sim.setRaw(code, value)
+}
+
+// setRaw Store the raw value of a register in the format it uses.
+func (sim *Simulator) setRaw(code int, raw float64) {
+	reg := sim.registers[code]
+	var byts []byte
+	switch reg.Format {
+	case "ieee32":
+		byts = make([]byte, 4)
+		binary.BigEndian.PutUint32(byts, math.Float32bits(float32(raw)))
+	default:
+		lower, upper := reg.limits()
+		if reg.Factor != 0 && reg.Format != "coil" {
+			lower, upper = lower/reg.Factor, upper/reg.Factor
+		}
+		byts, _ = formatIntAsBytes(reg.Format, int(math.Round(math.Max(lower, math.Min(upper, raw)))))
+	}
+	regs := sim.holding
+	if getRegisterType(code) == 3 {
+		regs = sim.input
+	}
+	for n := 0; n < len(byts)/2; n++ {
+		regs[reg.Register+uint16(n)] = binary.BigEndian.Uint16(byts[n*2:])
+	}
+}
@@ -0,0 +1,184 @@
+package modbusdev
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestSimulatorConfig(t *testing.T) {
+	tests := []struct {
+		cfg SimulatorConfig
+		err string
+	}{
+		{SimulatorConfig{Device: "toaster"}, "Device 'toaster' is not known. Add the details and then update reader.go to include it"},
+		{SimulatorConfig{Device: "solaxx1hybrid", Values: map[string]SimulatedValue{"pv3_power": {}}},
+			"No register named 'pv3_power'"},
+		{SimulatorConfig{Device: "solaxx1hybrid", Values: map[string]SimulatedValue{"pv1_power": {Curve: "sine"}}},
+			"A sine curve for pv1_power requires a Period"},
+		{SimulatorConfig{Device: "solaxx1hybrid", Values: map[string]SimulatedValue{"pv1_power": {Curve: "square"}}},
+			"Unknown curve 'square' for pv1_power"},
+	}
+	for _, tst := range tests {
+		_, err := NewSimulator(tst.cfg)
+		if err == nil || err.Error() != tst.err {
+			t.Fatalf("Incorrect error. Got %v expected %s", err, tst.err)
+		}
+	}
+}
+
+func TestSimulator(t *testing.T) {
+	sim, err := NewSimulator(SimulatorConfig{Device: "solaxx1hybridex", Address: "127.0.0.1:0", SlaveID: 1,
+		Values: map[string]SimulatedValue{
+			"grid_voltage":     {Value: 240.5},
+			"battery_current":  {Value: -12.3},
+			"max_export_power": {Value: 3000},
+			"pv1_power":        {Curve: "sine", Min: 1000, Max: 1000, Period: 60},
+			"feed_in_energy":   {Curve: "increment", Value: 100, Step: 3600},
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sim.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+
+	handler := modbus.NewTCPClientHandler(sim.Addr())
+	handler.SlaveId = 1
+	handler.Timeout = time.Second
+	defer handler.Close()
+	client := modbus.NewClient(handler)
+
+	rdr, err := NewReader(client, "solaxx1hybridex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings, err := rdr.Readings(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]float64{30001: 240.5, 30022: -12.3, 40183: 3000, 30011: 1000}
+	for code, val := range expected {
+		if got := readings.FactoredValue(code); math.Abs(got-val) > 0.001 {
+			t.Fatalf("Incorrect value for %d. Got %f expected %f", code, got, val)
+		}
+	}
+	first := readings.FactoredValue(30073)
+	if first < 100 {
+		t.Fatalf("Incorrect value for feed_in_energy. Got %f expected at least 100", first)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if readings, err = rdr.Readings(true); err != nil {
+		t.Fatal(err)
+	}
+	if readings.FactoredValue(30073) <= first {
+		t.Fatalf("feed_in_energy did not increase. Got %f", readings.FactoredValue(30073))
+	}
+
+	wrt, err := NewWriter(client, "solaxx1hybridex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = wrt.WriteFactored(40183, 2500); err != nil {
+		t.Fatal(err)
+	}
+	if err = wrt.WriteSimple(40139, 26); err != nil {
+		t.Fatal(err)
+	}
+	if err = sim.Set("pv1_power", 1500); err != nil {
+		t.Fatal(err)
+	}
+	if readings, err = rdr.Readings(true); err != nil {
+		t.Fatal(err)
+	}
+	expected = map[int]float64{40183: 2500, 40139: 26, 30011: 1500}
+	for code, val := range expected {
+		if got := readings.FactoredValue(code); got != val {
+			t.Fatalf("Incorrect value for %d after write. Got %f expected %f", code, got, val)
+		}
+	}
+
+	handler.SlaveId = 2
+	if _, err = client.ReadInputRegisters(0, 1); err == nil || !strings.Contains(err.Error(), "exception '11'") {
+		t.Fatalf("Incorrect error for another slave. Got %v", err)
+	}
+	handler.SlaveId = 1
+	if _, err = client.ReadInputRegisters(0, 126); err == nil || !strings.Contains(err.Error(), "quantity") {
+		t.Fatalf("Incorrect error for too many registers. Got %v", err)
+	}
+	if _, err = client.ReadCoils(0, 1); err == nil || !strings.Contains(err.Error(), "exception '1'") {
+		t.Fatalf("Incorrect error for an unsupported function. Got %v", err)
+	}
+}
+
+func TestSimulatorSnapshot(t *testing.T) {
+	client := NewFakeClient()
+	client.Input[0x0a] = 1234
+	client.Input[0x15] = 0xff85
+	rdr, err := NewReader(client, "solaxx1hybrid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{"json", "jsonl"} {
+		var buf bytes.Buffer
+		if err = rdr.Dump(&buf, format); err != nil {
+			t.Fatal(err)
+		}
+		filename := filepath.Join(t.TempDir(), "snapshot."+format)
+		if err = os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sim, err := NewSimulator(SimulatorConfig{Device: "solaxx1hybrid", Snapshot: filename,
+			Values: map[string]SimulatedValue{"pv2_power": {Value: 99}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sim.input[0x0a] != 1234 || sim.input[0x15] != 0xff85 || sim.input[0x0b] != 99 {
+			t.Fatalf("Incorrect values from %s snapshot. Got %d, %d and %d", format, sim.input[0x0a],
+				sim.input[0x15], sim.input[0x0b])
+		}
+	}
+}
+
+func TestSimulatorCloseWhileConnecting(t *testing.T) {
+	sim, err := NewSimulator(SimulatorConfig{Device: "sdm230", Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sim.Open(); err != nil {
+		t.Fatal(err)
+	}
+	addr := sim.Addr()
+	conns := make(chan net.Conn, 100)
+	go func() {
+		defer close(conns)
+		for i := 0; i < 100; i++ {
+			conn, err := net.DialTimeout("tcp", addr, time.Second)
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+	time.Sleep(time.Millisecond)
+	if err = sim.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Every connection should have been closed by the simulator
+	for conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err := conn.Read(make([]byte, 1))
+		conn.Close()
+		if netErr, ck := err.(net.Error); err == nil || (ck && netErr.Timeout()) {
+			t.Fatalf("Connection was left open after Close: %v", err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package modbusdev
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Readings The results of reading a device, along with details of the registers read.
+type Readings struct {
+	Device     string
+	SlaveID    byte
+	Time       time.Time
+	Registers  map[int]Register
+	Composites map[int]Composite
+	// Names The names of the registers, any not included are named from their description.
+	Names  map[int]string
+	Values map[int]Value
+	// Factored Set if the factors have been applied to the Values
+	Factored bool
+}
+
+// Name Return the name of the register or composite for the code.
+func (r Readings) Name(code int) string {
+	if name, ck := r.Names[code]; ck {
+		return name
+	}
+	if reg, ck := r.Registers[code]; ck {
+		return reg.name()
+	}
+	return r.Composites[code].Name
+}
+
+// code Return the code of the register or composite with the given name.
+func (r Readings) code(name string) (int, error) {
+	return codeByName(registerNames(r.Registers, r.Names), r.Composites, name)
+}
+
+// Description Return the description of the register or composite for the code.
+func (r Readings) Description(code int) string {
+	if reg, ck := r.Registers[code]; ck {
+		return reg.Description
+	}
+	return r.Composites[code].Description
+}
+
+// Units Return the units of the register for the code.
+func (r Readings) Units(code int) string {
+	return r.Registers[code].Units
+}
+
+// Raw Return the raw value of the register for the code as a float.
+func (r Readings) Raw(code int) float64 {
+	reg := r.Registers[code]
+	val := r.Values[code]
+	if r.Factored && reg.Format == "ieee32" && reg.Factor != 0 {
+		return val.Ieee32 / reg.Factor
+	}
+	return reg.rawValue(val)
+}
+
+// FactoredValue Return the value of the register for the code after the factor has been applied.
+func (r Readings) FactoredValue(code int) float64 {
+	val := r.Values[code]
+	if !r.Factored {
+		r.Registers[code].applyFactor(&val)
+	}
+	return val.Ieee32
+}
+
+// ReadingValue The details and value of a single register or composite from Readings.
+type ReadingValue struct {
+	Code        int         `json:"code"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Raw         *float64    `json:"raw,omitempty"`
+	Value       interface{} `json:"value"`
+	Units       string      `json:"units,omitempty"`
+	Time        time.Time   `json:"time"`
+}
+
+// Codes Return the codes of all values in the readings, in order.
+func (r Readings) Codes() []int {
+	codes := make([]int, 0, len(r.Values))
+	for code := range r.Values {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	return codes
+}
+
+// selectCodes Return the codes of the registers or composites with the given names (or codes)
+// that have values. If no names are given all codes are returned.
+func (r Readings) selectCodes(names []string) ([]int, error) {
+	if len(names) == 0 {
+		return r.Codes(), nil
+	}
+	var codes []int
+	for _, name := range names {
+		code, err := r.code(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, ck := r.Values[code]; ck {
+			codes = append(codes, code)
+		}
+	}
+	return codes, nil
+}
+
+// Value Return the details and value for the code. Composite values are text, coils are
+// booleans and all other registers are numbers with the factor applied.
+func (r Readings) Value(code int) ReadingValue {
+	rv := ReadingValue{Code: code, Name: r.Name(code), Description: r.Description(code),
+		Units: r.Units(code), Time: r.Time}
+	if _, ck := r.Composites[code]; ck {
+		rv.Value = r.Values[code].Text
+		return rv
+	}
+	raw := r.Raw(code)
+	rv.Raw = &raw
+	if r.Registers[code].Format == "coil" {
+		rv.Value = r.Values[code].Coil
+	} else {
+		rv.Value = r.FactoredValue(code)
+	}
+	return rv
+}
+
+// Sink Interface implemented by destinations that readings can be written to.
+type Sink interface {
+	Open() error
+	Write(readings Readings) error
+	Close() error
+}
+
+// SinkConfig JSON configuration of a Sink. The Type selects the sink and Config contains the
+// configuration for that type of sink.
+type SinkConfig struct {
+	Type   string
+	Config json.RawMessage
+}
+
+// NewSink Return a new, unopened, Sink from the supplied configuration.
+func NewSink(cfg SinkConfig) (sink Sink, err error) {
+	switch strings.ToLower(cfg.Type) {
+	case "postgres", "postgresql":
+		sink = &DatabaseConnection{}
+	case "sqlite", "sqlite3":
+		sink = &SQLiteConnection{}
+	case "buffered":
+		sink = &BufferedSink{}
+	case "influx", "influxdb":
+		sink = &InfluxSink{}
+	case "mqtt":
+		sink = &MQTTSink{}
+	case "csv":
+		sink = &CSVSink{}
+	default:
+		return nil, fmt.Errorf("Sink type '%s' is not known", cfg.Type)
+	}
+	if len(cfg.Config) > 0 {
+		if err = json.Unmarshal(cfg.Config, sink); err != nil {
+			return nil, fmt.Errorf("Unable to parse configuration for %s sink: %s", cfg.Type, err)
+		}
+	}
+	return sink, nil
+}
+
+// Sinks A collection of sinks that are all written the same readings.
+type Sinks []Sink
+
+// NewSinks Return the Sinks described by the supplied configurations.
+func NewSinks(cfgs []SinkConfig) (sinks Sinks, err error) {
+	for _, cfg := range cfgs {
+		sink, err := NewSink(cfg)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return
+}
+
+// Open Open all sinks. If any fail to open, those already opened are closed.
+func (sinks Sinks) Open() error {
+	for i, sink := range sinks {
+		if err := sink.Open(); err != nil {
+			for _, opened := range sinks[:i] {
+				opened.Close()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Write Write the readings to all sinks. A failure of one sink does not prevent the readings
+// being written to the others. The first error encountered is returned.
+func (sinks Sinks) Write(readings Readings) (err error) {
+	for _, sink := range sinks {
+		if sErr := sink.Write(readings); sErr != nil {
+			log.Printf("Error writing readings to sink: %s", sErr)
+			if err == nil {
+				err = sErr
+			}
+		}
+	}
+	return
+}
+
+// Close Close all sinks, returning the first error encountered.
+func (sinks Sinks) Close() (err error) {
+	for _, sink := range sinks {
+		if sErr := sink.Close(); sErr != nil && err == nil {
+			err = sErr
+		}
+	}
+	return
+}
@@ -0,0 +1,87 @@
+package modbusdev
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+type memorySink struct {
+	opened   bool
+	closed   bool
+	readings []Readings
+	fail     bool
+}
+
+func (ms *memorySink) Open() error {
+	ms.opened = true
+	return nil
+}
+
+func (ms *memorySink) Write(readings Readings) error {
+	if ms.fail {
+		return fmt.Errorf("write failed")
+	}
+	ms.readings = append(ms.readings, readings)
+	return nil
+}
+
+func (ms *memorySink) Close() error {
+	ms.closed = true
+	return nil
+}
+
+func TestSinks(t *testing.T) {
+	client := NewFakeClient()
+	client.Input[0x0a] = 1234
+	rdr, err := NewReader(client, "solaxx1hybrid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings, err := rdr.Readings(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readings.Name(30011) != "pv1_power" || readings.Units(30011) != "W" {
+		t.Fatalf("Incorrect register details in readings")
+	}
+
+	failing := &memorySink{fail: true}
+	working := &memorySink{}
+	sinks := Sinks{failing, working}
+	if err := sinks.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sinks.Write(readings); err == nil {
+		t.Fatal("Expected error from failing sink")
+	}
+	if len(working.readings) != 1 || working.readings[0].Values[30011].Ieee32 != 1234 {
+		t.Fatalf("Readings not written to working sink")
+	}
+	sinks.Close()
+	if !failing.closed || !working.closed {
+		t.Fatal("Sinks were not closed")
+	}
+}
+
+func TestNewSinks(t *testing.T) {
+	var cfgs []SinkConfig
+	err := json.Unmarshal([]byte(`[{"type": "postgres", "config": {"host": "localhost", "port": 5432, "fields": [{"name": "pv1", "code": 30011}]}}]`), &cfgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinks, err := NewSinks(cfgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbC, ck := sinks[0].(*DatabaseConnection)
+	if !ck {
+		t.Fatalf("Incorrect sink type %T", sinks[0])
+	}
+	if dbC.Host != "localhost" || dbC.Port != 5432 || len(dbC.Fields) != 1 {
+		t.Fatalf("Configuration not parsed: %+v", dbC)
+	}
+	if _, err := NewSink(SinkConfig{Type: "unknown"}); err == nil {
+		t.Fatal("Expected error for unknown sink type")
+	}
+}
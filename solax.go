@@ -0,0 +1,175 @@
+package modbusdev
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+const (
+	// Registers used to write the force time use charge periods. Each period has a start and
+	// finish time in consecutive registers.
+	solaxChargePeriod1Write = 0x26
+	solaxChargePeriod2Write = 0x2A
+
+	// Maximum battery charge/discharge current accepted by the inverter.
+	solaxMaxCurrent = 25.0
+)
+
+// SolaxTime A time of day, as used for the Solax charge and backup periods.
+type SolaxTime struct {
+	Hour   uint16
+	Minute uint16
+}
+
+// ParseSolaxTime Parse a time of day given as HH:MM.
+func ParseSolaxTime(hhmm string) (st SolaxTime, err error) {
+	tm, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return st, fmt.Errorf("Invalid time '%s', expected HH:MM", hhmm)
+	}
+	return SolaxTime{uint16(tm.Hour()), uint16(tm.Minute())}, nil
+}
+
+func (st SolaxTime) String() string {
+	return fmt.Sprintf("%02d:%02d", st.Hour, st.Minute)
+}
+
+func (st SolaxTime) validate() error {
+	if st.Hour > 23 || st.Minute > 59 {
+		return fmt.Errorf("Invalid time %s", st)
+	}
+	return nil
+}
+
+// packed When written, a time is sent as a single register with the hour in the low byte and
+// the minutes in the high byte.
+func (st SolaxTime) packed() uint16 {
+	return st.Hour + st.Minute<<8
+}
+
+// SolaxPeriod A period of time between two times of day.
+type SolaxPeriod struct {
+	Start SolaxTime
+	End   SolaxTime
+}
+
+// SolaxSettings The current settings of a Solax X1 Hybrid inverter.
+type SolaxSettings struct {
+	MinCapacity         uint16
+	ChargeMaxCurrent    float64
+	DischargeMaxCurrent float64
+	ChargePeriods       [2]SolaxPeriod
+	BackupPeriod        SolaxPeriod
+	MaxExportPower      uint16
+	RatedPower          uint16
+}
+
+// SolaxX1Hybrid Typed access to the settings of a Solax X1 Hybrid inverter. Values are validated
+// before being written and the correct write registers are used.
+type SolaxX1Hybrid struct {
+	reader Reader
+	writer Writer
+}
+
+// NewSolaxX1Hybrid Return a SolaxX1Hybrid using the supplied client.
+func NewSolaxX1Hybrid(client modbus.Client) (sx SolaxX1Hybrid, err error) {
+	sx.reader, err = NewReader(client, "solaxx1hybridex")
+	if err != nil {
+		return
+	}
+	sx.writer, err = NewWriter(client, "solaxx1hybridex")
+	return
+}
+
+// SetAudit Set the audit hook used for all writes.
+func (sx *SolaxX1Hybrid) SetAudit(hook AuditHook) {
+	sx.writer.SetAudit(hook)
+}
+
+// GetSettings Read the current settings from the inverter.
+func (sx *SolaxX1Hybrid) GetSettings() (settings SolaxSettings, err error) {
+	if err = sx.reader.Read(); err != nil {
+		return
+	}
+	get := func(code int) uint16 {
+		val, _ := sx.reader.Get(code, false)
+		return val.Unsigned16
+	}
+	getTime := func(code int) SolaxTime {
+		return SolaxTime{get(code), get(code + 1)}
+	}
+	getFactored := func(code int) float64 {
+		val, _ := sx.reader.Get(code, true)
+		return math.Round(val.Ieee32*100) / 100
+	}
+
+	settings.MinCapacity = get(40140)
+	settings.ChargeMaxCurrent = getFactored(40145)
+	settings.DischargeMaxCurrent = getFactored(40146)
+	settings.ChargePeriods[0] = SolaxPeriod{getTime(40147), getTime(40149)}
+	settings.ChargePeriods[1] = SolaxPeriod{getTime(40155), getTime(40157)}
+	settings.BackupPeriod = SolaxPeriod{getTime(40255), getTime(40257)}
+	settings.MaxExportPower = get(40183)
+	settings.RatedPower = get(40187)
+	return
+}
+
+// SetChargePeriod Set the start and end of force time use charge period n (1 or 2).
+func (sx *SolaxX1Hybrid) SetChargePeriod(n int, start, end SolaxTime) error {
+	var address uint16
+	switch n {
+	case 1:
+		address = solaxChargePeriod1Write
+	case 2:
+		address = solaxChargePeriod2Write
+	default:
+		return fmt.Errorf("Charge period %d is not valid, must be 1 or 2", n)
+	}
+	if err := start.validate(); err != nil {
+		return err
+	}
+	if err := end.validate(); err != nil {
+		return err
+	}
+	if err := sx.writer.WriteDirect(address, start.packed()); err != nil {
+		return err
+	}
+	return sx.writer.WriteDirect(address+1, end.packed())
+}
+
+// SetMaxExportPower Set the maximum power, in W, that can be exported. This cannot exceed the
+// rated power of the inverter.
+func (sx *SolaxX1Hybrid) SetMaxExportPower(watts uint16) error {
+	rated, err := sx.reader.ReadRegister(40187, false)
+	if err != nil {
+		return err
+	}
+	if rated.Unsigned16 > 0 && watts > rated.Unsigned16 {
+		return fmt.Errorf("Max export power of %dW exceeds rated power of %dW", watts, rated.Unsigned16)
+	}
+	return sx.writer.WriteSimple(40183, int(watts))
+}
+
+// SetChargeCurrentLimits Set the maximum battery charge and discharge currents, in A.
+func (sx *SolaxX1Hybrid) SetChargeCurrentLimits(charge, discharge float64) error {
+	for _, current := range []float64{charge, discharge} {
+		if math.IsNaN(current) || current < 0 || current > solaxMaxCurrent {
+			return fmt.Errorf("Current of %.1fA is not valid, must be between 0 and %.1fA", current, solaxMaxCurrent)
+		}
+	}
+	if err := sx.writer.WriteFactored(40145, charge); err != nil {
+		return err
+	}
+	return sx.writer.WriteFactored(40146, discharge)
+}
+
+// SetMinCapacity Set the minimum battery capacity, as a percentage.
+func (sx *SolaxX1Hybrid) SetMinCapacity(percent uint16) error {
+	if percent > 100 {
+		return fmt.Errorf("Capacity of %d%% is not valid", percent)
+	}
+	return sx.writer.WriteSimple(40140, int(percent))
+}
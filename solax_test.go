@@ -0,0 +1,91 @@
+package modbusdev
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSolaxSetChargePeriod(t *testing.T) {
+	client := NewFakeClient()
+	sx, err := NewSolaxX1Hybrid(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sx.SetChargePeriod(2, SolaxTime{0, 30}, SolaxTime{5, 15}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if client.Holding[0x2A] != 0x1E00 || client.Holding[0x2B] != 0x0F05 {
+		t.Fatalf("Incorrect values written. Got %04X and %04X", client.Holding[0x2A], client.Holding[0x2B])
+	}
+	if err := sx.SetChargePeriod(3, SolaxTime{0, 30}, SolaxTime{5, 15}); err == nil {
+		t.Fatal("Expected error for invalid period")
+	}
+	if err := sx.SetChargePeriod(1, SolaxTime{24, 0}, SolaxTime{5, 15}); err == nil {
+		t.Fatal("Expected error for invalid time")
+	}
+}
+
+func TestSolaxLimits(t *testing.T) {
+	client := NewFakeClient()
+	sx, err := NewSolaxX1Hybrid(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sx.SetChargeCurrentLimits(10.5, 20); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if client.Holding[0x24] != 105 || client.Holding[0x25] != 200 {
+		t.Fatalf("Incorrect currents written. Got %d and %d", client.Holding[0x24], client.Holding[0x25])
+	}
+	if err := sx.SetChargeCurrentLimits(-1, 20); err == nil {
+		t.Fatal("Expected error for negative current")
+	}
+	if err := sx.SetChargeCurrentLimits(10, math.NaN()); err == nil {
+		t.Fatal("Expected error for NaN current")
+	}
+
+	client.Holding[0xBA] = 3000
+	if err := sx.SetMaxExportPower(3500); err == nil {
+		t.Fatal("Expected error for export power above rated power")
+	}
+	if err := sx.SetMaxExportPower(2500); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if client.Holding[0x42] != 2500 {
+		t.Fatalf("Incorrect export power written. Got %d", client.Holding[0x42])
+	}
+}
+
+func TestSolaxGetSettings(t *testing.T) {
+	client := NewFakeClient()
+	client.Holding[0x8C] = 20
+	client.Holding[0x90] = 155
+	client.Holding[0x92] = 1
+	client.Holding[0x93] = 30
+	client.Holding[0x9C] = 7
+	client.Holding[0xB6] = 2500
+	sx, err := NewSolaxX1Hybrid(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings, err := sx.GetSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.MinCapacity != 20 || settings.ChargeMaxCurrent != 15.5 || settings.MaxExportPower != 2500 {
+		t.Fatalf("Incorrect settings returned: %+v", settings)
+	}
+	if settings.ChargePeriods[0].Start.String() != "01:30" || settings.ChargePeriods[1].End.Hour != 7 {
+		t.Fatalf("Incorrect charge periods returned: %+v", settings.ChargePeriods)
+	}
+}
+
+func TestParseSolaxTime(t *testing.T) {
+	st, err := ParseSolaxTime("23:05")
+	if err != nil || st.Hour != 23 || st.Minute != 5 {
+		t.Fatalf("Incorrect time parsed: %v %s", st, err)
+	}
+	if _, err := ParseSolaxTime("25:00"); err == nil {
+		t.Fatal("Expected error for invalid time")
+	}
+}
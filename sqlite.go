@@ -0,0 +1,130 @@
+package modbusdev
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	// Import sqlite access
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteConnection Structure that holds details of a local SQLite database that readings are
+// stored in. Fields and Query are used in the same way as for a DatabaseConnection. If no Query
+// is given then the Table will be created (if needed) with a time column and a column for each
+// field.
+type SQLiteConnection struct {
+	Filename string
+	Table    string
+
+	Query  string
+	Fields []DatabaseField
+	// Device Required when fields are identified by Register name rather than Code
+	Device string
+
+	db        *sql.DB
+	statement *sql.Stmt
+	registers map[int]Register
+	// timed Set when the generated Query takes the time of the readings as the first parameter.
+	timed bool
+}
+
+// Open Open the database file, creating the table if required.
+func (sqC *SQLiteConnection) Open() error {
+	if err := resolveFields(sqC.Device, sqC.Fields); err != nil {
+		return err
+	}
+	regs, err := deviceRegisters(sqC.Device)
+	if err != nil {
+		return err
+	}
+	sqC.registers = regs
+	if sqC.Query == "" && sqC.Table == "" {
+		return fmt.Errorf("Either a Query or Table must be specified")
+	}
+	db, err := sql.Open("sqlite3", sqC.Filename)
+	if err != nil {
+		return err
+	}
+	sqC.db = db
+	if err = sqC.db.Ping(); err != nil {
+		sqC.db.Close()
+		return err
+	}
+	if sqC.Query == "" {
+		if err = sqC.createTable(); err != nil {
+			sqC.db.Close()
+			return err
+		}
+		sqC.Query = fmt.Sprintf("INSERT INTO %s (time%%s) VALUES (?%%s)", sqC.Table)
+		sqC.timed = true
+	}
+	qry := insertQuery(sqC.Query, sqC.Fields, func(int) string { return "?" })
+	sqC.statement, err = sqC.db.Prepare(qry)
+	if err != nil {
+		log.Printf("Error creating insertion statement")
+		sqC.db.Close()
+		return err
+	}
+	return nil
+}
+
+func (sqC *SQLiteConnection) createTable() error {
+	columns := []string{"time TIMESTAMP NOT NULL"}
+	for _, fld := range sqC.Fields {
+		switch strings.ToLower(fld.Type) {
+		case "int", "bool":
+			columns = append(columns, fld.Name+" INTEGER")
+		case "string", "enum":
+			columns = append(columns, fld.Name+" TEXT")
+		default:
+			columns = append(columns, fld.Name+" REAL")
+		}
+	}
+	_, err := sqC.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", sqC.Table, strings.Join(columns, ", ")))
+	return err
+}
+
+// Execute Execute the stored query using supplied map of values
+func (sqC *SQLiteConnection) Execute(data map[int]Value) error {
+	return sqC.execute(sqC.registers, data, time.Now())
+}
+
+// execute Execute the stored query. The time is only stored when the query was generated.
+func (sqC *SQLiteConnection) execute(registers map[int]Register, data map[int]Value, tm time.Time) error {
+	qryData, err := fieldData(sqC.Fields, registers, data)
+	if err != nil {
+		return err
+	}
+	if sqC.timed {
+		qryData = append([]interface{}{tm}, qryData...)
+	}
+	if _, err = sqC.statement.Exec(qryData...); err != nil {
+		log.Printf("Error writing sensor data to database: %s", err)
+		return err
+	}
+	return nil
+}
+
+// Write Insert the readings into the database, using the time of the readings if the Query was
+// generated.
+func (sqC *SQLiteConnection) Write(readings Readings) error {
+	tm := readings.Time
+	if tm.IsZero() {
+		tm = time.Now()
+	}
+	return sqC.execute(readings.Registers, readings.Values, tm)
+}
+
+// Close Close the database, closing the statement as well.
+func (sqC *SQLiteConnection) Close() error {
+	if sqC.db == nil {
+		return nil
+	}
+	if sqC.statement != nil {
+		sqC.statement.Close()
+	}
+	return sqC.db.Close()
+}
@@ -0,0 +1,51 @@
+package modbusdev
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLiteConnection(t *testing.T) {
+	sqC := SQLiteConnection{
+		Filename: filepath.Join(t.TempDir(), "test.db"),
+		Table:    "readings",
+		Device:   "solaxx1hybrid",
+		Fields: []DatabaseField{
+			{Name: "pv1", Register: "pv1_power"},
+			{Name: "inverter", Code: 30003},
+		},
+	}
+	if err := sqC.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer sqC.Close()
+
+	read := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	readings := Readings{Time: read, Values: map[int]Value{30011: {Ieee32: 1234}, 30003: {Ieee32: -56}}}
+	for i := 0; i < 2; i++ {
+		if err := sqC.Write(readings); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sqC.Write(Readings{Values: map[int]Value{30011: {}}}); err == nil {
+		t.Fatal("Expected error writing readings with missing values")
+	}
+
+	var count int
+	var pv1, inverter float64
+	err := sqC.db.QueryRow("SELECT COUNT(*), MAX(pv1), MAX(inverter) FROM readings").Scan(&count, &pv1, &inverter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || pv1 != 1234 || inverter != -56 {
+		t.Fatalf("Incorrect data stored. Got %d rows, pv1 %f, inverter %f", count, pv1, inverter)
+	}
+	var stored time.Time
+	if err = sqC.db.QueryRow("SELECT time FROM readings LIMIT 1").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Equal(read) {
+		t.Fatalf("Incorrect time stored. Got %s expected %s", stored, read)
+	}
+}
@@ -0,0 +1,237 @@
+package modbusdev
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStreamBuffer    = 16
+	defaultStreamKeepAlive = 30
+)
+
+// Stream A Sink that passes each set of readings to browsers connected using Server-Sent
+// Events. Add it to the Sinks used by the polling loop and serve it as an http.Handler.
+//
+// Clients can choose what they receive using query parameters,
+//
+//	device     Only readings from this device
+//	registers  A comma separated list of register names or codes
+//	changes    If set to 1, only registers whose value has changed are sent
+//
+// Each client has a queue of Buffer events. If a client is too slow and the queue is full the
+// oldest event is dropped, so slow clients never delay the polling loop.
+type Stream struct {
+	Buffer int
+	// KeepAlive Seconds between comments sent to keep idle connections open.
+	KeepAlive int
+
+	mu      sync.Mutex
+	clients map[*streamClient]struct{}
+	done    chan struct{}
+}
+
+// StreamEvent The data sent to clients for each set of readings.
+type StreamEvent struct {
+	Device  string         `json:"device"`
+	SlaveID byte           `json:"slave_id"`
+	Time    time.Time      `json:"time"`
+	Values  []ReadingValue `json:"values"`
+}
+
+type streamClient struct {
+	device    string
+	registers []string
+	changes   bool
+	events    chan []byte
+	last      map[string]map[int]interface{}
+	dropped   uint64
+}
+
+// NewStream Return a Stream with no clients.
+func NewStream() *Stream {
+	return &Stream{}
+}
+
+// Open Prepare the stream to accept clients.
+func (st *Stream) Open() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.Buffer <= 0 {
+		st.Buffer = defaultStreamBuffer
+	}
+	if st.KeepAlive <= 0 {
+		st.KeepAlive = defaultStreamKeepAlive
+	}
+	if st.clients == nil {
+		st.clients = make(map[*streamClient]struct{})
+	}
+	st.done = make(chan struct{})
+	return nil
+}
+
+// Write Queue the readings for each connected client. This never waits for clients.
+func (st *Stream) Write(readings Readings) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for client := range st.clients {
+		event, ok := client.event(readings)
+		if !ok {
+			continue
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		client.send(data)
+	}
+	return nil
+}
+
+// Close Disconnect all clients.
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.done != nil {
+		close(st.done)
+		st.done = nil
+	}
+	return nil
+}
+
+// Clients Return the number of connected clients.
+func (st *Stream) Clients() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return len(st.clients)
+}
+
+// ServeHTTP Stream events to the client until it disconnects or the stream is closed.
+func (st *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	client := &streamClient{device: strings.ToLower(r.URL.Query().Get("device")),
+		changes: r.URL.Query().Get("changes") == "1"}
+	for _, name := range strings.Split(r.URL.Query().Get("registers"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			client.registers = append(client.registers, name)
+		}
+	}
+
+	st.mu.Lock()
+	if st.done == nil {
+		st.mu.Unlock()
+		http.Error(w, "Stream is not open", http.StatusServiceUnavailable)
+		return
+	}
+	done := st.done
+	client.events = make(chan []byte, st.Buffer)
+	st.clients[client] = struct{}{}
+	keepAlive := time.NewTicker(time.Duration(st.KeepAlive) * time.Second)
+	st.mu.Unlock()
+	defer func() {
+		keepAlive.Stop()
+		st.mu.Lock()
+		delete(st.clients, client)
+		st.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		var err error
+		select {
+		case data := <-client.events:
+			_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// send Queue the event, dropping the oldest queued event if the queue is full. Must be called
+// with the stream locked.
+func (sc *streamClient) send(data []byte) {
+	for {
+		select {
+		case sc.events <- data:
+			return
+		default:
+		}
+		select {
+		case <-sc.events:
+			sc.dropped++
+			if sc.dropped == 1 || sc.dropped%100 == 0 {
+				log.Printf("Stream client is too slow, %d events dropped", sc.dropped)
+			}
+			// The client has missed changes, so send everything next time.
+			sc.last = nil
+		default:
+		}
+	}
+}
+
+// event Return the event for the client, or false if there is nothing to send.
+func (sc *streamClient) event(readings Readings) (StreamEvent, bool) {
+	event := StreamEvent{Device: readings.Device, SlaveID: readings.SlaveID, Time: readings.Time}
+	if sc.device != "" && sc.device != readings.Device {
+		return event, false
+	}
+	codes := readings.Codes()
+	if len(sc.registers) > 0 {
+		codes = nil
+		for _, name := range sc.registers {
+			code, err := readings.code(name)
+			if err != nil {
+				continue
+			}
+			if _, ck := readings.Values[code]; ck {
+				codes = append(codes, code)
+			}
+		}
+	}
+
+	key := readings.Device + "/" + strconv.Itoa(int(readings.SlaveID))
+	var last map[int]interface{}
+	if sc.changes {
+		if sc.last == nil {
+			sc.last = make(map[string]map[int]interface{})
+		}
+		if last = sc.last[key]; last == nil {
+			last = make(map[int]interface{})
+			sc.last[key] = last
+		}
+	}
+	for _, code := range codes {
+		val := readings.Value(code)
+		if last != nil {
+			if prev, ck := last[code]; ck && prev == val.Value {
+				continue
+			}
+			last[code] = val.Value
+		}
+		event.Values = append(event.Values, val)
+	}
+	return event, len(event.Values) > 0
+}
@@ -0,0 +1,109 @@
+package modbusdev
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readEvent(t *testing.T, rdr *bufio.Reader) StreamEvent {
+	var event StreamEvent
+	for {
+		line, err := rdr.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(line, "data: ") {
+			if err := json.Unmarshal([]byte(line[6:]), &event); err != nil {
+				t.Fatal(err)
+			}
+			return event
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	st := NewStream()
+	if err := st.Open(); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(st)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?device=solaxx1hybrid&registers=pv1_power,30003&changes=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Incorrect content type %s", resp.Header.Get("Content-Type"))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for st.Clients() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	readings := influxReadings(t)
+	other := readings
+	other.Device = "sdm230"
+	for _, r := range []Readings{other, readings} {
+		if err := st.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rdr := bufio.NewReader(resp.Body)
+	event := readEvent(t, rdr)
+	if event.Device != "solaxx1hybrid" || len(event.Values) != 2 || event.Values[0].Name != "pv1_power" {
+		t.Fatalf("Incorrect event %+v", event)
+	}
+
+	changed := Readings{Device: readings.Device, SlaveID: readings.SlaveID, Time: readings.Time,
+		Registers: readings.Registers, Values: make(map[int]Value), Factored: true}
+	for code, val := range readings.Values {
+		changed.Values[code] = val
+	}
+	val := changed.Values[30003]
+	val.Ieee32 = 99
+	changed.Values[30003] = val
+	st.Write(changed)
+	event = readEvent(t, rdr)
+	if len(event.Values) != 1 || event.Values[0].Code != 30003 || event.Values[0].Value != 99.0 {
+		t.Fatalf("Incorrect event %+v", event)
+	}
+
+	st.Close()
+	deadline = time.Now().Add(5 * time.Second)
+	for st.Clients() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st.Clients() != 0 {
+		t.Fatal("Client was not disconnected on close")
+	}
+}
+
+func TestStreamSlowClient(t *testing.T) {
+	sc := &streamClient{events: make(chan []byte, 2), changes: true}
+	readings := influxReadings(t)
+	if _, ok := sc.event(readings); !ok {
+		t.Fatal("Expected values in first event")
+	}
+	if _, ok := sc.event(readings); ok {
+		t.Fatal("Expected no values when nothing has changed")
+	}
+	for i := 0; i < 5; i++ {
+		sc.send([]byte("{}"))
+	}
+	if sc.dropped != 3 || len(sc.events) != 2 {
+		t.Fatalf("Incorrect value. Got %d dropped expected 3", sc.dropped)
+	}
+	if sc.last != nil {
+		t.Fatal("Changes were not reset after dropping events")
+	}
+	if _, ok := sc.event(readings); !ok {
+		t.Fatal("Expected all values to be sent after dropping events")
+	}
+}
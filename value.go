@@ -13,6 +13,7 @@ type Value struct {
 	Signed32   int32
 	Coil       bool
 	Ieee32     float64
+	Text       string
 }
 
 // FormatBytes Given a format string and some bytes, attempt to correctly format them
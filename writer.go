@@ -3,29 +3,36 @@ package modbusdev
 import (
 	"bytes"
 	"fmt"
+	"log"
+	"math"
 	"strings"
+	"time"
 
 	"github.com/goburrow/modbus"
 )
 
-// Reader A reader structure allows us to tie a client to a device register map
+// Writer A writer structure allows us to tie a client to the writable registers of a device
 type Writer struct {
-	client    modbus.Client
-	registers map[int]Register
+	client         modbus.Client
+	device         string
+	registers      map[int]Register
+	names          map[int]string
+	writeAddresses map[int]uint16
+	audit          AuditHook
 }
 
 // NewWriter Return a configured Writer with the correct register mappings.
 // Device names are converted to lower case for matching, so case provided is irrelevant.
 func NewWriter(client modbus.Client, device string) (wrt Writer, err error) {
 	wrt.client = client
-	switch strings.ToLower(device) {
-	case "sdm230":
-		wrt.addRegisters(sdm230)
-	case "solaxx1hybrid":
-		wrt.addRegisters(solaxX1Hybrid)
-	default:
-		err = fmt.Errorf("Device '%s' is not known. Add the details and then update reader.go & writer.go to include it", device)
+	wrt.device = strings.ToLower(device)
+	regs, err := RegistersByName(device)
+	if err != nil {
+		return
 	}
+	wrt.addRegisters(regs)
+	wrt.names = registerNames(wrt.registers, namesByName(device))
+	wrt.writeAddresses = writeAddressesByName(device)
 	return
 }
 
@@ -38,6 +45,12 @@ func (wrt *Writer) addRegisters(possible map[int]Register) {
 	}
 }
 
+// SetAudit Set the hook that will be given details of every write made. Passing nil disables
+// auditing.
+func (wrt *Writer) SetAudit(hook AuditHook) {
+	wrt.audit = hook
+}
+
 // WriteSimple Write a given int value to a register after converting the type (if possible)
 func (wrt *Writer) WriteSimple(code, value int) error {
 	reg, ck := wrt.registers[code]
@@ -50,45 +63,169 @@ func (wrt *Writer) WriteSimple(code, value int) error {
 		if err != nil {
 			return err
 		}
-		return wrt.writeSingle(reg, bytes)
+		return wrt.writeSingle(code, reg, bytes)
 	default:
 		return fmt.Errorf("Cannot convert int to %s", reg.Format)
 	}
 }
 
+// WriteByName Write a given int value to the register with the given name.
+func (wrt *Writer) WriteByName(name string, value int) error {
+	code, err := codeByName(registerNames(wrt.registers, wrt.names), nil, name)
+	if err != nil {
+		return err
+	}
+	return wrt.WriteSimple(code, value)
+}
+
+// RangeError The error returned when a value is outside the range that can be written to a
+// register.
+type RangeError struct {
+	Value float64
+	Lower float64
+	Upper float64
+}
+
+func (e RangeError) Error() string {
+	return fmt.Sprintf("Value %g is outside the range %g to %g", e.Value, e.Lower, e.Upper)
+}
+
+// WriteFactored Write a value that has the factor applied, e.g. 12.5 A, converting it to the raw
+// register value. The value is checked to be within the range the register can hold, so NaN and
+// infinite values are rejected.
+func (wrt *Writer) WriteFactored(code int, value float64) error {
+	reg, ck := wrt.registers[code]
+	if !ck {
+		return fmt.Errorf("Register %d unknown", code)
+	}
+	if !reg.writable() {
+		return fmt.Errorf("Unable to write registers with format %s", reg.Format)
+	}
+	if lower, upper := reg.limits(); math.IsNaN(value) || math.IsInf(value, 0) || value < lower || value > upper {
+		return RangeError{value, lower, upper}
+	}
+	factor := reg.Factor
+	if factor == 0 {
+		factor = 1
+	}
+	return wrt.WriteSimple(code, int(math.Round(value/factor)))
+}
+
 // WriteRegister Write a given value to a register
 func (wrt *Writer) WriteRegister(code int, val Value) error {
 	reg, ck := wrt.registers[code]
 	if !ck {
 		return fmt.Errorf("Register %d unknown", code)
 	}
-	return wrt.writeSingle(reg, val.asBytes(reg.Format))
+	return wrt.writeSingle(code, reg, val.asBytes(reg.Format))
 }
 
-// WriteDirect Write the given values to the specified register
+// WriteDirect Write the given value to the specified address. If the address is used to write
+// a known register the write is audited as that register, otherwise only the address is recorded.
 func (wrt *Writer) WriteDirect(address, value uint16) error {
-	rrr, err := wrt.client.WriteSingleRegister(address, value)
-	//	rrr, err := wrt.client.WriteMultipleRegisters(address, uint16(len(byts)), byts)
-	//	if !bytes.Equal(rrr, byts) {
-	//		fmt.Printf("Did not get expected return value: %v != %v\n", rrr, byts)
-	//	}
-	fmt.Printf("rrr = %v\n", rrr)
+	code, reg, ck := wrt.registerAt(address)
+	var previous Value
+	if ck {
+		previous = wrt.auditPrevious(reg)
+	} else {
+		reg = Register{Register: address, Format: "u16", Factor: 1}
+	}
+	_, err := wrt.client.WriteSingleRegister(address, value)
+	wrt.auditWrite(code, reg, address, previous, []byte{byte(value >> 8), byte(value)}, err)
+	return err
+}
+
+// registerAt Return the single register that is written using the address, if there is one.
+func (wrt *Writer) registerAt(address uint16) (int, Register, bool) {
+	for code, writeAddress := range wrt.writeAddresses {
+		if reg, ck := wrt.registers[code]; ck && writeAddress == address && reg.registersRqd() == 1 {
+			return code, reg, true
+		}
+	}
+	for code, reg := range wrt.registers {
+		if _, ck := wrt.writeAddresses[code]; !ck && reg.Register == address && reg.registersRqd() == 1 {
+			return code, reg, true
+		}
+	}
+	return 0, Register{}, false
+}
+
+// writeAddress Return the address that should be used when writing the register. For most devices
+// this is the same as the address it is read from.
+func (wrt *Writer) writeAddress(code int, reg Register) uint16 {
+	if address, ck := wrt.writeAddresses[code]; ck {
+		return address
+	}
+	return reg.Register
+}
+
+func (wrt *Writer) writeSingle(code int, reg Register, byts []byte) error {
+	previous := wrt.auditPrevious(reg)
+	address := wrt.writeAddress(code, reg)
+	err := wrt.writeBytes(reg, address, byts)
+	wrt.auditWrite(code, reg, address, previous, byts, err)
 	return err
 }
 
-func (wrt *Writer) writeSingle(reg Register, byts []byte) error {
+func (wrt *Writer) writeBytes(reg Register, address uint16, byts []byte) error {
 	switch reg.Format {
 	case "u16", "s16":
 		uval := uint16(byts[0])<<8 + uint16(byts[1])
-		rrr, err := wrt.client.WriteSingleRegister(reg.Register, uval)
+		rrr, err := wrt.client.WriteSingleRegister(address, uval)
 		if err != nil {
 			return err
 		}
-		fmt.Printf("Return: %v vs %v\n", rrr, byts)
 		if !bytes.Equal(rrr, byts) {
-			fmt.Printf("WriteSingle did not return identical values. %v != %v\n", rrr, byts)
 			return fmt.Errorf("Incorrect return from write. %v != %v", rrr, byts)
 		}
+	case "u32", "s32":
+		_, err := wrt.client.WriteMultipleRegisters(address, reg.registersRqd(), byts)
+		return err
+	default:
+		return fmt.Errorf("Unable to write registers with format %s", reg.Format)
 	}
 	return nil
 }
+
+// auditPrevious When auditing is enabled, read the current value of the register so it can be
+// recorded alongside the new value.
+func (wrt *Writer) auditPrevious(reg Register) (val Value) {
+	if wrt.audit == nil {
+		return
+	}
+	results, err := wrt.client.ReadHoldingRegisters(reg.Register, reg.registersRqd())
+	if err != nil {
+		log.Printf("Unable to read previous value of register %d for audit: %s", reg.Register, err)
+		return
+	}
+	if len(results) < int(reg.registersRqd())*2 {
+		log.Printf("Short response reading previous value of register %d for audit", reg.Register)
+		return
+	}
+	val.FormatBytes(reg.Format, results)
+	return
+}
+
+func (wrt *Writer) auditWrite(code int, reg Register, address uint16, previous Value, byts []byte, err error) {
+	if wrt.audit == nil {
+		return
+	}
+	record := AuditRecord{
+		Time:        time.Now(),
+		Device:      wrt.device,
+		Code:        code,
+		Address:     address,
+		Description: reg.Description,
+		Previous:    previous,
+		Result:      "ok",
+	}
+	if len(byts) >= int(reg.registersRqd())*2 {
+		record.New.FormatBytes(reg.Format, byts)
+	}
+	if err != nil {
+		record.Result = err.Error()
+	}
+	if aErr := wrt.audit.Audit(record); aErr != nil {
+		log.Printf("Unable to record audit of write to %d [%04X]: %s", code, address, aErr)
+	}
+}
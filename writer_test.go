@@ -0,0 +1,102 @@
+package modbusdev
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestWriter(t *testing.T) {
+	client := NewFakeClient()
+	wrt, err := NewWriter(client, "solaxx1hybridex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = wrt.WriteSimple(40026, 2100); err != nil {
+		t.Fatal(err)
+	}
+	if err = wrt.WriteFactored(40183, 2500.4); err != nil {
+		t.Fatal(err)
+	}
+	if err = wrt.WriteByName("min_charger_capacity", 20); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := client.Requests()
+	expected := []FakeRequest{
+		{Function: modbus.FuncCodeWriteSingleRegister, Address: 0x19, Quantity: 1, Values: []byte{0x08, 0x34}},
+		{Function: modbus.FuncCodeWriteSingleRegister, Address: 0x42, Quantity: 1, Values: []byte{0x09, 0xC4}},
+		{Function: modbus.FuncCodeWriteSingleRegister, Address: 0x22, Quantity: 1, Values: []byte{0x00, 0x14}},
+	}
+	if len(reqs) != len(expected) {
+		t.Fatalf("Incorrect value. Got %d requests expected %d", len(reqs), len(expected))
+	}
+	for i, req := range reqs {
+		exp := expected[i]
+		if req.Function != exp.Function || req.Address != exp.Address || !bytes.Equal(req.Values, exp.Values) {
+			t.Fatalf("Incorrect request. Got %+v expected %+v", req, exp)
+		}
+	}
+}
+
+func TestWriterMultiple(t *testing.T) {
+	client := NewFakeClient()
+	wrt, err := NewWriter(client, "sdm230ex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = wrt.WriteSimple(463761, 0x00020003); err != nil {
+		t.Fatal(err)
+	}
+	if client.GetHolding(0xf910) != 2 || client.GetHolding(0xf911) != 3 {
+		t.Fatalf("Incorrect value. Got %d %d expected 2 3", client.GetHolding(0xf910), client.GetHolding(0xf911))
+	}
+	reqs := client.Requests()
+	if len(reqs) != 1 || reqs[0].Function != modbus.FuncCodeWriteMultipleRegisters || reqs[0].Quantity != 2 {
+		t.Fatalf("Incorrect requests made %+v", reqs)
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	client := NewFakeClient()
+	wrt, err := NewWriter(client, "solaxx1hybridex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		write func() error
+		err   string
+	}{
+		{func() error { return wrt.WriteSimple(30001, 1) }, "Register 30001 unknown"},
+		{func() error { return wrt.WriteByName("pv9_power", 1) }, "No register named 'pv9_power'"},
+		{func() error { return wrt.WriteFactored(40183, 70000) }, "Value 70000 is outside the range 0 to 65535"},
+		{func() error { return wrt.WriteFactored(40026, -1) }, "Value -1 is outside the range 0 to 6553.5"},
+		{func() error { return wrt.WriteFactored(40183, math.NaN()) }, "Value NaN is outside the range 0 to 65535"},
+		{func() error { return wrt.WriteFactored(40026, math.Inf(-1)) }, "Value -Inf is outside the range 0 to 6553.5"},
+	}
+	for _, tst := range tests {
+		if err := tst.write(); err == nil || err.Error() != tst.err {
+			t.Fatalf("Incorrect error. Got %v expected %s", err, tst.err)
+		}
+	}
+	if len(client.Requests()) != 0 {
+		t.Fatalf("Requests made for invalid writes %+v", client.Requests())
+	}
+
+	client.AddFault(FakeFault{Function: modbus.FuncCodeWriteSingleRegister, Exception: modbus.ExceptionCodeIllegalDataValue, Count: 1})
+	err = wrt.WriteSimple(40183, 100)
+	if err == nil || !strings.Contains(err.Error(), "exception '3'") {
+		t.Fatalf("Incorrect error. Got %v expected an illegal data value exception", err)
+	}
+	client.AddFault(FakeFault{Function: modbus.FuncCodeWriteSingleRegister, Short: true, Count: 1})
+	err = wrt.WriteSimple(40183, 100)
+	if err == nil || !strings.HasPrefix(err.Error(), "Incorrect return from write") {
+		t.Fatalf("Incorrect error. Got %v expected an incorrect return", err)
+	}
+	if err = wrt.WriteSimple(40183, 100); err != nil {
+		t.Fatal(err)
+	}
+}